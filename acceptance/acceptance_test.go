@@ -236,7 +236,7 @@ func testPack(t *testing.T, when spec.G, it spec.S) {
 
 			t.Log("build app with builder:", builderRepoName)
 			NewDockerDaemon().Pull(t, "packs/run", "latest")
-			cmd = exec.Command(pack, "build", appRepoName, "-p", filepath.Join(tmpDir, "app"), "--builder", builderRepoName, "--no-pull", "--run-image", "packs/run")
+			cmd = exec.Command(pack, "build", appRepoName, "-p", filepath.Join(tmpDir, "app"), "--builder", builderRepoName, "--pull-policy", "never", "--run-image", "packs/run")
 			cmd.Env = append(os.Environ(), "HOME="+homeDir)
 			run(t, cmd)
 
@@ -250,7 +250,7 @@ func testPack(t *testing.T, when spec.G, it spec.S) {
 
 	when("add-stack", func() {
 		it("adds a custom stack to ~/.pack/config.toml", func() {
-			cmd := exec.Command(pack, "add-stack", "my.custom.stack", "--run-image", "my-org/run", "--build-image", "my-org/build")
+			cmd := exec.Command(pack, "add-stack", "my.custom.stack", "--run-image", "my-org/run", "--build-image", "my-org/build", "--skip-validation")
 			cmd.Env = append(os.Environ(), "HOME="+homeDir)
 			output, err := cmd.CombinedOutput()
 			if err != nil {
@@ -286,7 +286,7 @@ func testPack(t *testing.T, when spec.G, it spec.S) {
 		}
 
 		it.Before(func() {
-			cmd := exec.Command(pack, "add-stack", "my.custom.stack", "--run-image", "my-org/run", "--build-image", "my-org/build")
+			cmd := exec.Command(pack, "add-stack", "my.custom.stack", "--run-image", "my-org/run", "--build-image", "my-org/build", "--skip-validation")
 			cmd.Env = append(os.Environ(), "HOME="+homeDir)
 			output, err := cmd.CombinedOutput()
 			if err != nil {
@@ -295,7 +295,7 @@ func testPack(t *testing.T, when spec.G, it spec.S) {
 		})
 
 		it("updates an existing custom stack in ~/.pack/config.toml", func() {
-			cmd := exec.Command(pack, "update-stack", "my.custom.stack", "--run-image", "my-org/run-2", "--run-image", "my-org/run-3", "--build-image", "my-org/build-2", "--build-image", "my-org/build-3")
+			cmd := exec.Command(pack, "update-stack", "my.custom.stack", "--run-image", "my-org/run-2", "--run-image", "my-org/run-3", "--build-image", "my-org/build-2", "--build-image", "my-org/build-3", "--skip-validation")
 			cmd.Env = append(os.Environ(), "HOME="+homeDir)
 			output, err := cmd.CombinedOutput()
 			if err != nil {
@@ -312,6 +312,25 @@ func testPack(t *testing.T, when spec.G, it spec.S) {
 			assertEq(t, stack.BuildImages, []string{"my-org/build-2", "my-org/build-3"})
 			assertEq(t, stack.RunImages, []string{"my-org/run-2", "my-org/run-3"})
 		})
+
+		it("adds and removes individual images of an existing custom stack", func() {
+			cmd := exec.Command(pack, "update-stack", "my.custom.stack", "--add-run-image", "registry.com/my-org/run", "--add-run-image", "my-org/run", "--remove-build-image", "my-org/build", "--add-build-image", "my-org/build-2", "--skip-validation")
+			cmd.Env = append(os.Environ(), "HOME="+homeDir)
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("update-stack command failed: %s: %s", output, err)
+			}
+			assertEq(t, string(output), "my.custom.stack successfully updated\n")
+
+			var config config
+			_, err = toml.DecodeFile(filepath.Join(homeDir, ".pack", "config.toml"), &config)
+			assertNil(t, err)
+
+			stack := config.Stacks[len(config.Stacks)-1]
+			assertEq(t, stack.ID, "my.custom.stack")
+			assertEq(t, stack.BuildImages, []string{"my-org/build-2"})
+			assertEq(t, stack.RunImages, []string{"my-org/run", "registry.com/my-org/run"})
+		})
 	}, spec.Parallel(), spec.Report(report.Terminal{}))
 
 	when("delete-stack", func() {
@@ -332,7 +351,7 @@ func testPack(t *testing.T, when spec.G, it spec.S) {
 		}
 
 		it.Before(func() {
-			cmd := exec.Command(pack, "add-stack", "my.custom.stack", "--run-image", "my-org/run", "--build-image", "my-org/build")
+			cmd := exec.Command(pack, "add-stack", "my.custom.stack", "--run-image", "my-org/run", "--build-image", "my-org/build", "--skip-validation")
 			cmd.Env = append(os.Environ(), "HOME="+homeDir)
 			output, err := cmd.CombinedOutput()
 			if err != nil {
@@ -361,6 +380,71 @@ func testPack(t *testing.T, when spec.G, it spec.S) {
 			assertEq(t, containsStack(config, "my.custom.stack"), false)
 		})
 	}, spec.Parallel(), spec.Report(report.Terminal{}))
+
+	when("stacks", func() {
+		it.Before(func() {
+			cmd := exec.Command(pack, "add-stack", "my.custom.stack", "--run-image", "my-org/run", "--build-image", "my-org/build", "--skip-validation")
+			cmd.Env = append(os.Environ(), "HOME="+homeDir)
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("add-stack command failed: %s: %s", output, err)
+			}
+		})
+
+		it("lists the stacks from ~/.pack/config.toml as json", func() {
+			cmd := exec.Command(pack, "stacks", "--output", "json")
+			cmd.Env = append(os.Environ(), "HOME="+homeDir)
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("stacks command failed: %s: %s", output, err)
+			}
+
+			var stacks []struct {
+				ID          string   `json:"id"`
+				Default     bool     `json:"default"`
+				BuildImages []string `json:"build-images"`
+				RunImages   []string `json:"run-images"`
+			}
+			assertNil(t, json.Unmarshal(output, &stacks))
+			assertEq(t, len(stacks), 2)
+			assertEq(t, stacks[0].ID, "io.buildpacks.stacks.bionic")
+			assertEq(t, stacks[0].Default, true)
+			assertEq(t, stacks[1].ID, "my.custom.stack")
+			assertEq(t, stacks[1].Default, false)
+			assertEq(t, stacks[1].BuildImages, []string{"my-org/build"})
+			assertEq(t, stacks[1].RunImages, []string{"my-org/run"})
+		})
+	}, spec.Parallel(), spec.Report(report.Terminal{}))
+
+	when("set-default-stack", func() {
+		type config struct {
+			DefaultStackID string `toml:"default-stack-id"`
+		}
+
+		it.Before(func() {
+			cmd := exec.Command(pack, "add-stack", "my.custom.stack", "--run-image", "my-org/run", "--build-image", "my-org/build", "--skip-validation")
+			cmd.Env = append(os.Environ(), "HOME="+homeDir)
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("add-stack command failed: %s: %s", output, err)
+			}
+		})
+
+		it("sets the default-stack-id in ~/.pack/config.toml", func() {
+			cmd := exec.Command(pack, "set-default-stack", "my.custom.stack")
+			cmd.Env = append(os.Environ(), "HOME="+homeDir)
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("set-default-stack command failed: %s: %s", output, err)
+			}
+			assertEq(t, string(output), "my.custom.stack is now the default stack\n")
+
+			var config config
+			_, err = toml.DecodeFile(filepath.Join(homeDir, ".pack", "config.toml"), &config)
+			assertNil(t, err)
+			assertEq(t, config.DefaultStackID, "my.custom.stack")
+		})
+	}, spec.Parallel(), spec.Report(report.Terminal{}))
 }
 
 func run(t *testing.T, cmd *exec.Cmd) string {
@@ -2,8 +2,9 @@ package pack
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
 	"context"
-	"crypto/md5"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,6 +12,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -22,9 +24,13 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/pack/docker"
+	"github.com/buildpack/packs"
 	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
 	dockercli "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -46,15 +52,47 @@ type BuildFlags struct {
 	RunImage string
 	RepoName string
 	Publish  bool
-	NoPull   bool
+	// PublishSet is true when Publish was given explicitly, so that it takes
+	// precedence over publish in the project descriptor even when false
+	PublishSet bool
+	// PullPolicy is one of always, if-not-present or never, defaults to
+	// default-pull-policy in the pack config
+	PullPolicy string
+	Env        []string
+	EnvFile    string
+	Exclude    []string
+	// ClearCache removes the cache volume before building
+	ClearCache bool
+	// OutputOCI and OutputTar export the image to an OCI image layout
+	// directory or a docker save tarball instead of the daemon
+	OutputOCI string
+	OutputTar string
+	// CacheKey names the build cache, defaults to the absolute AppDir
+	CacheKey string
+	// CacheKeyFromImage uses the repository of RepoName as cache key, so that
+	// builds of the same image share a cache wherever the app is checked out
+	CacheKeyFromImage bool
+	// Descriptor is the path to the project descriptor, defaults to
+	// project.toml in AppDir
+	Descriptor string
+	// DigestFile receives the digest of the image when it is published or
+	// written to an OCI image layout, and its image ID otherwise
+	DigestFile string
 }
 
 type BuildConfig struct {
-	AppDir   string
-	Builder  string
-	RunImage string
-	RepoName string
-	Publish  bool
+	AppDir     string
+	Builder    string
+	RunImage   string
+	RepoName   string
+	Publish    bool
+	Env        map[string]string
+	Exclude    []string
+	ClearCache bool
+	CacheKey   string
+	OutputOCI  string
+	OutputTar  string
+	DigestFile string
 	// Above are copied from BuildFlags are set by init
 	Cli    Docker
 	Stdout io.Writer
@@ -64,50 +102,132 @@ type BuildConfig struct {
 	Config *config.Config
 	Images Images
 	// Above are copied from BuildFactory
+	// BuildID identifies the resources created by the build, see labels
+	BuildID         string
 	WorkspaceVolume string
 	CacheVolume     string
+	// OnEvent, when set, receives the events of the build as they happen
+	OnEvent func(BuildEvent)
+
+	result *BuildResult
 }
 
-func DefaultBuildFactory() (*BuildFactory, error) {
+func DefaultBuildFactory(registryAuthFile string, insecureRegistries []string) (*BuildFactory, error) {
 	f := &BuildFactory{
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
 		Log:    log.New(os.Stdout, "", log.LstdFlags),
 		FS:     &fs.FS{},
-		Images: &image.Client{},
 	}
 
-	var err error
-	f.Cli, err = docker.New()
+	cli, err := docker.New(registryAuthFile)
 	if err != nil {
 		return nil, err
 	}
+	f.Cli = cli
 
 	f.Config, err = config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
 	if err != nil {
 		return nil, err
 	}
+	f.Images = &image.Client{
+		InsecureRegistries: append(append([]string{}, f.Config.InsecureRegistries...), insecureRegistries...),
+		Keychain:           cli.Keychain.Authn(),
+	}
 
 	return f, nil
 }
 
-func (bf *BuildFactory) BuildConfigFromFlags(f *BuildFlags) (*BuildConfig, error) {
+func cacheKey(appDir string, f *BuildFlags) (string, error) {
+	if f.CacheKey != "" && f.CacheKeyFromImage {
+		return "", errors.New("cache-key and cache-key-from-image cannot be used together")
+	}
+	if f.CacheKey != "" {
+		return f.CacheKey, nil
+	}
+	if f.CacheKeyFromImage {
+		ref, err := name.ParseReference(f.RepoName, name.WeakValidation)
+		if err != nil {
+			return "", errors.Wrapf(err, "cache key from image %s", f.RepoName)
+		}
+		return ref.Context().Name(), nil
+	}
+	return appDir, nil
+}
+
+// BuildConfigFromFlags resolves the images and the cache of the build, and
+// pulls the builder and run images as the pull policy allows. The pulls stop
+// when ctx is cancelled.
+func (bf *BuildFactory) BuildConfigFromFlags(ctx context.Context, f *BuildFlags) (*BuildConfig, error) {
 	appDir, err := filepath.Abs(f.AppDir)
 	if err != nil {
 		return nil, err
 	}
-	if !f.NoPull {
-		bf.Log.Printf("Pulling builder image '%s' (use --no-pull flag to skip this step)", f.Builder)
-		if err := bf.Cli.PullImage(f.Builder); err != nil {
+
+	env := map[string]string{}
+	descriptorPath := f.Descriptor
+	if descriptorPath == "" {
+		descriptorPath = filepath.Join(appDir, defaultDescriptor)
+	}
+	descriptor, err := ReadProjectDescriptor(descriptorPath, f.Descriptor != "")
+	if err != nil {
+		return nil, err
+	}
+	if descriptor != nil {
+		bf.Log.Printf("Using project descriptor '%s'", descriptorPath)
+		f = descriptor.applyTo(f)
+		if err := addEnv(env, descriptor.EnvFile, descriptor.envList()); err != nil {
 			return nil, err
 		}
 	}
+	exclude, err := fs.ReadExcludesFile(filepath.Join(appDir, ".packignore"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read .packignore")
+	}
+	if descriptor != nil {
+		exclude = append(exclude, descriptor.Exclude...)
+	}
+	exclude = append(exclude, f.Exclude...)
+	if _, err := fs.NewExcludes(exclude); err != nil {
+		return nil, errors.Wrap(err, "invalid exclude pattern")
+	}
+	if f.RepoName == "" {
+		return nil, errors.New("missing image name: provide one as an argument or as 'image' in the project descriptor")
+	}
+	if err := validateOutputFlags(f.Publish, f.OutputOCI, f.OutputTar); err != nil {
+		return nil, err
+	}
+	if err := addEnv(env, f.EnvFile, f.Env); err != nil {
+		return nil, err
+	}
+	cacheKey, err := cacheKey(appDir, f)
+	if err != nil {
+		return nil, err
+	}
+
+	pullPolicy, err := resolvePullPolicy(f.PullPolicy, bf.Config.DefaultPullPolicy)
+	if err != nil {
+		return nil, err
+	}
 
+	builder := bf.builder(f.Builder)
+	if err := pullImage(ctx, bf.Cli, bf.Log, pullPolicy, "builder image", builder); err != nil {
+		return nil, err
+	}
+
+	buildID := uuid.New().String()
 	b := &BuildConfig{
-		AppDir:  appDir,
-		Builder: f.Builder,
+		AppDir:          appDir,
+		Builder:         builder,
 		RepoName:        f.RepoName,
 		Publish:         f.Publish,
+		Env:             env,
+		Exclude:         exclude,
+		ClearCache:      f.ClearCache,
+		CacheKey:        cacheKey,
+		OutputOCI:       f.OutputOCI,
+		OutputTar:       f.OutputTar,
+		DigestFile:      f.DigestFile,
 		Cli:             bf.Cli,
 		Stdout:          bf.Stdout,
 		Stderr:          bf.Stderr,
@@ -115,11 +235,12 @@ func (bf *BuildFactory) BuildConfigFromFlags(f *BuildFlags) (*BuildConfig, error
 		FS:              bf.FS,
 		Config:          bf.Config,
 		Images:          bf.Images,
-		WorkspaceVolume: fmt.Sprintf("pack-workspace-%x", uuid.New().String()),
-		CacheVolume:     fmt.Sprintf("pack-cache-%x", md5.Sum([]byte(appDir))),
+		BuildID:         buildID,
+		WorkspaceVolume: fmt.Sprintf("pack-workspace-%x", buildID),
+		CacheVolume:     CacheVolumeName(cacheKey),
 	}
 
-	builderStackID, err := b.imageLabel(f.Builder, "io.buildpacks.stack.id", true)
+	builderStackID, err := b.imageLabel(b.Builder, "io.buildpacks.stack.id", true)
 	if err != nil {
 		return nil, fmt.Errorf(`invalid builder image "%s": %s`, b.Builder, err)
 	}
@@ -146,9 +267,8 @@ func (bf *BuildFactory) BuildConfigFromFlags(f *BuildFlags) (*BuildConfig, error
 		b.Log.Printf("Selected run image '%s' from stack '%s'\n", b.RunImage, builderStackID)
 	}
 
-	if !f.NoPull && !f.Publish {
-		bf.Log.Printf("Pulling run image '%s' (use --no-pull flag to skip this step)", b.RunImage)
-		if err := bf.Cli.PullImage(b.RunImage); err != nil {
+	if !f.Publish {
+		if err := pullImage(ctx, bf.Cli, bf.Log, pullPolicy, "run image", b.RunImage); err != nil {
 			return nil, err
 		}
 	}
@@ -164,12 +284,35 @@ func (bf *BuildFactory) BuildConfigFromFlags(f *BuildFlags) (*BuildConfig, error
 	return b, nil
 }
 
+const defaultBuilder = "packs/samples"
+
+// builder resolves the builder image from, in order of precedence, the
+// provided name, the default builder of the default stack, the default
+// builder in the pack config and finally the built-in default.
+func (bf *BuildFactory) builder(name string) string {
+	if name != "" {
+		bf.Log.Printf("Using user provided builder '%s'", name)
+		return name
+	}
+	if stack, err := bf.Config.Get(""); err == nil && stack.DefaultBuilder != "" {
+		bf.Log.Printf("Using default builder '%s' from default stack '%s'", stack.DefaultBuilder, stack.ID)
+		return stack.DefaultBuilder
+	}
+	if bf.Config.DefaultBuilder != "" {
+		bf.Log.Printf("Using default builder '%s' from pack config", bf.Config.DefaultBuilder)
+		return bf.Config.DefaultBuilder
+	}
+	bf.Log.Printf("Using built-in default builder '%s'", defaultBuilder)
+	return defaultBuilder
+}
+
 func Build(appDir, buildImage, runImage, repoName string, publish bool) error {
-	bf, err := DefaultBuildFactory()
+	bf, err := DefaultBuildFactory("", nil)
 	if err != nil {
 		return err
 	}
-	b, err := bf.BuildConfigFromFlags(&BuildFlags{
+	ctx := context.Background()
+	b, err := bf.BuildConfigFromFlags(ctx, &BuildFlags{
 		AppDir:   appDir,
 		Builder:  buildImage,
 		RunImage: runImage,
@@ -179,41 +322,76 @@ func Build(appDir, buildImage, runImage, repoName string, publish bool) error {
 	if err != nil {
 		return err
 	}
-	return b.Run()
+	_, err = b.Run(ctx)
+	return err
 }
 
-func (b *BuildConfig) Run() error {
+// Run runs all the phases of the build and returns what it did. When ctx is
+// cancelled the running phase stops, and its containers and the workspace
+// volume are removed before Run returns. The result is returned even when the
+// build fails, with the phases that ran and the error.
+func (b *BuildConfig) Run(ctx context.Context) (*BuildResult, error) {
+	b.result = newBuildResult(b.RepoName)
+	defer func() { b.result = nil }()
+	result := b.result
+	if err := b.run(ctx); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+	return result, nil
+}
+
+func (b *BuildConfig) run(ctx context.Context) error {
+	b.emit(BuildEvent{Type: EventRunImage, RunImage: b.RunImage})
+
+	if _, err := b.Cli.VolumeCreate(ctx, volume.VolumeCreateBody{
+		Name:   b.WorkspaceVolume,
+		Labels: b.labels(),
+	}); err != nil {
+		return errors.Wrap(err, "create workspace volume")
+	}
 	defer b.Cli.VolumeRemove(context.Background(), b.WorkspaceVolume, true)
 
-	fmt.Println("*** DETECTING:")
-	group, err := b.Detect()
-	if err != nil {
+	if b.ClearCache {
+		b.Log.Printf("Clearing cache volume '%s'", b.CacheVolume)
+		if err := b.Cli.VolumeRemove(ctx, b.CacheVolume, true); err != nil && !dockercli.IsErrNotFound(err) {
+			return errors.Wrap(err, "clear cache volume")
+		}
+	}
+	if err := ensureCacheVolume(b.Cli, b.CacheVolume, b.CacheKey, b.AppDir); err != nil {
 		return err
 	}
 
-	fmt.Println("*** ANALYZING: Reading information from previous image for possible re-use")
-	if err := b.Analyze(); err != nil {
+	fmt.Fprintln(b.Stdout, "*** DETECTING:")
+	var group *lifecycle.BuildpackGroup
+	if err := b.phase("detect", func() (err error) {
+		group, err = b.Detect(ctx)
+		return err
+	}); err != nil {
 		return err
 	}
+	b.emitDetected(group)
 
-	fmt.Println("*** BUILDING:")
-	if err := b.Build(); err != nil {
+	fmt.Fprintln(b.Stdout, "*** ANALYZING: Reading information from previous image for possible re-use")
+	if err := b.phase("analyze", func() error { return b.Analyze(ctx) }); err != nil {
 		return err
 	}
 
-	fmt.Println("*** EXPORTING:")
-	if err := b.Export(group); err != nil {
+	fmt.Fprintln(b.Stdout, "*** BUILDING:")
+	if err := b.phase("build", func() error { return b.Build(ctx) }); err != nil {
 		return err
 	}
 
-	return nil
+	fmt.Fprintln(b.Stdout, "*** EXPORTING:")
+	return b.phase("export", func() error { return b.Export(ctx, group) })
 }
 
-func (b *BuildConfig) Detect() (*lifecycle.BuildpackGroup, error) {
-	ctx := context.Background()
+func (b *BuildConfig) Detect(ctx context.Context) (*lifecycle.BuildpackGroup, error) {
 	ctr, err := b.Cli.ContainerCreate(ctx, &container.Config{
-		Image: b.Builder,
-		Cmd:   []string{"/lifecycle/detector"},
+		Image:  b.Builder,
+		Labels: b.labels(),
+		Cmd:    []string{"/lifecycle/detector"},
+		Env:    b.envList(),
 	}, &container.HostConfig{
 		Binds: []string{
 			b.WorkspaceVolume + ":/workspace",
@@ -222,14 +400,14 @@ func (b *BuildConfig) Detect() (*lifecycle.BuildpackGroup, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "container create")
 	}
-	defer b.Cli.ContainerRemove(ctx, ctr.ID, dockertypes.ContainerRemoveOptions{})
+	defer removeContainer(b.Cli, ctr.ID)
 
 	uid, gid, err := b.packUidGid(b.Builder)
 	if err != nil {
 		return nil, errors.Wrap(err, "detect")
 	}
 
-	tr, errChan := b.FS.CreateTarReader(b.AppDir, "/workspace/app", uid, gid)
+	tr, errChan := b.FS.CreateTarReader(b.AppDir, "/workspace/app", uid, gid, b.Exclude)
 	if err := b.Cli.CopyToContainer(ctx, ctr.ID, "/", tr, dockertypes.CopyToContainerOptions{}); err != nil {
 		return nil, errors.Wrap(err, "copy app to workspace volume")
 	}
@@ -237,18 +415,28 @@ func (b *BuildConfig) Detect() (*lifecycle.BuildpackGroup, error) {
 		return nil, errors.Wrap(err, "copy app to workspace volume")
 	}
 
-	if err := b.chownDir("/workspace/app", uid, gid); err != nil {
+	if err := b.chownDir(ctx, "/workspace/app", uid, gid); err != nil {
 		return nil, errors.Wrap(err, "chown app to workspace volume")
 	}
 
+	if len(b.Env) > 0 {
+		tr, err := platformEnvTar(b.Env, uid, gid)
+		if err != nil {
+			return nil, errors.Wrap(err, "create tar with platform env")
+		}
+		if err := b.Cli.CopyToContainer(ctx, ctr.ID, "/", tr, dockertypes.CopyToContainerOptions{}); err != nil {
+			return nil, errors.Wrap(err, "copy platform env to workspace volume")
+		}
+	}
+
 	if err := b.Cli.RunContainer(ctx, ctr.ID, b.Stdout, b.Stderr); err != nil {
 		return nil, errors.Wrap(err, "run detect container")
 	}
-	return b.groupToml(ctr.ID)
+	return b.groupToml(ctx, ctr.ID)
 }
 
-func (b *BuildConfig) groupToml(ctrID string) (*lifecycle.BuildpackGroup, error) {
-	trc, _, err := b.Cli.CopyFromContainer(context.Background(), ctrID, "/workspace/group.toml")
+func (b *BuildConfig) groupToml(ctx context.Context, ctrID string) (*lifecycle.BuildpackGroup, error) {
+	trc, _, err := b.Cli.CopyFromContainer(ctx, ctrID, "/workspace/group.toml")
 	if err != nil {
 		return nil, errors.Wrap(err, "reading group.toml from container")
 	}
@@ -265,7 +453,11 @@ func (b *BuildConfig) groupToml(ctrID string) (*lifecycle.BuildpackGroup, error)
 	return &group, nil
 }
 
-func (b *BuildConfig) Analyze() error {
+func (b *BuildConfig) Analyze(ctx context.Context) error {
+	if b.OutputOCI != "" || b.OutputTar != "" {
+		b.Log.Printf("WARNING: skipping analyze, the image is exported to a file")
+		return nil
+	}
 	metadata, err := b.imageLabel(b.RepoName, lifecycle.MetadataLabel, !b.Publish)
 	if err != nil {
 		return errors.Wrap(err, "analyze image label")
@@ -279,10 +471,10 @@ func (b *BuildConfig) Analyze() error {
 		return nil
 	}
 
-	ctx := context.Background()
 	ctr, err := b.Cli.ContainerCreate(ctx, &container.Config{
-		Image: b.Builder,
-		Cmd:   []string{"/lifecycle/analyzer", "-metadata", "/workspace/imagemetadata.json", "-launch", "/workspace", b.RepoName},
+		Image:  b.Builder,
+		Labels: b.labels(),
+		Cmd:    []string{"/lifecycle/analyzer", "-metadata", "/workspace/imagemetadata.json", "-launch", "/workspace", b.RepoName},
 	}, &container.HostConfig{
 		Binds: []string{
 			b.WorkspaceVolume + ":/workspace",
@@ -291,7 +483,7 @@ func (b *BuildConfig) Analyze() error {
 	if err != nil {
 		return errors.Wrap(err, "analyze container create")
 	}
-	defer b.Cli.ContainerRemove(ctx, ctr.ID, dockertypes.ContainerRemoveOptions{})
+	defer removeContainer(b.Cli, ctr.ID)
 
 	tr, err := b.FS.CreateSingleFileTar("/workspace/imagemetadata.json", metadata)
 	if err != nil {
@@ -307,11 +499,12 @@ func (b *BuildConfig) Analyze() error {
 	return nil
 }
 
-func (b *BuildConfig) Build() error {
-	ctx := context.Background()
+func (b *BuildConfig) Build(ctx context.Context) error {
 	ctr, err := b.Cli.ContainerCreate(ctx, &container.Config{
-		Image: b.Builder,
-		Cmd:   []string{"/lifecycle/builder"},
+		Image:  b.Builder,
+		Labels: b.labels(),
+		Cmd:    []string{"/lifecycle/builder"},
+		Env:    b.envList(),
 	}, &container.HostConfig{
 		Binds: []string{
 			b.WorkspaceVolume + ":/workspace",
@@ -321,67 +514,233 @@ func (b *BuildConfig) Build() error {
 	if err != nil {
 		return errors.Wrap(err, "build container create")
 	}
-	defer b.Cli.ContainerRemove(ctx, ctr.ID, dockertypes.ContainerRemoveOptions{})
+	defer removeContainer(b.Cli, ctr.ID)
 
+	if err := markCacheUsed(ctx, b.Cli, ctr.ID); err != nil {
+		return err
+	}
 	return b.Cli.RunContainer(ctx, ctr.ID, b.Stdout, b.Stderr)
 }
 
-func (b *BuildConfig) Export(group *lifecycle.BuildpackGroup) error {
+// Export writes the image built in the workspace volume to the registry, the
+// daemon or a file, and emits its layers and IDs.
+func (b *BuildConfig) Export(ctx context.Context, group *lifecycle.BuildpackGroup) error {
+	out := &reuseRecorder{out: b.Stdout, reused: map[string]bool{}}
+	newImage, err := b.export(ctx, group, out)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := buildMetadata(newImage)
+	if err != nil {
+		return errors.Wrap(err, "read metadata of new image")
+	}
+	b.emitLayers(metadata, out.reused)
+
+	imageID, err := newImage.ConfigName()
+	if err != nil {
+		return packs.FailErr(err, "calculating image ID")
+	}
+	if !b.Publish && b.OutputOCI == "" && b.OutputTar == "" {
+		// the daemon only knows the image by its ID, not by a manifest digest
+		b.emit(BuildEvent{Type: EventImage, ImageID: imageID.String()})
+		b.Log.Printf("\n*** Image: %s\n*** Image ID: %s\n", b.RepoName, imageID)
+	} else {
+		digest, err := newImage.Digest()
+		if err != nil {
+			return packs.FailErr(err, "calculating image digest")
+		}
+		b.emit(BuildEvent{Type: EventImage, ImageID: imageID.String(), Digest: digest.String()})
+		switch {
+		case b.OutputOCI != "":
+			b.Log.Printf("\n*** Image: %s@%s written to %s\n", b.RepoName, digest, b.OutputOCI)
+		case b.OutputTar != "":
+			b.Log.Printf("\n*** Image: %s@%s written to %s\n", b.RepoName, digest, b.OutputTar)
+		default:
+			b.Log.Printf("\n*** Image: %s@%s\n", b.RepoName, digest)
+		}
+	}
+
+	if b.DigestFile != "" {
+		return writeDigestFile(b.DigestFile, newImage, b.Publish || b.OutputOCI != "")
+	}
+	return nil
+}
+
+// export exports the image, writing the output of the exporter to stdout.
+func (b *BuildConfig) export(ctx context.Context, group *lifecycle.BuildpackGroup, stdout io.Writer) (v1.Image, error) {
 	if b.Publish {
-		localWorkspaceDir, cleanup, err := b.exportVolume(b.Builder, b.WorkspaceVolume)
+		ctrID, cleanup, err := b.workspaceContainer(ctx)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer cleanup()
 
-		imgSHA, err := exportRegistry(group, localWorkspaceDir, b.RepoName, b.RunImage, b.Stdout, b.Stderr)
+		return exportRegistry(ctx, b.Cli, b.Images, ctrID, group, b.RepoName, b.RunImage, exportLabels(), stdout)
+	}
+
+	localWorkspaceDir, cleanup, err := b.exportVolume(ctx, b.Builder, b.WorkspaceVolume)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if b.OutputOCI != "" || b.OutputTar != "" {
+		stackImage, err := b.Images.ReadImage(b.RunImage, true)
+		if err != nil || stackImage == nil {
+			return nil, packs.FailErr(err, "get image for", b.RunImage)
+		}
+		store, err := outputStore(b.RepoName, b.OutputOCI, b.OutputTar)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		b.Log.Printf("\n*** Image: %s@%s\n", b.RepoName, imgSHA)
-	} else {
-		var buildpacks []string
-		for _, b := range group.Buildpacks {
-			buildpacks = append(buildpacks, b.ID)
+		return exportImage(group, localWorkspaceDir, b.RunImage, stackImage, nil, store, exportLabels(), stdout, b.Stderr)
+	}
+	return exportDaemon(ctx, b.Cli, b.Images, group, localWorkspaceDir, b.RepoName, b.RunImage, exportLabels(), stdout, b.Stderr)
+}
+
+// labels returns the labels set on the containers and volumes created by the
+// build, which allow pack gc to find them when the build is interrupted.
+func (b *BuildConfig) labels() map[string]string {
+	return map[string]string{
+		packVersionLabel: Version,
+		packBuildIDLabel: b.BuildID,
+	}
+}
+
+// exportLabels returns the labels set on the exported image. They must not
+// change from one build to the next, or every build would produce a new image
+// digest even when nothing changed.
+func exportLabels() map[string]string {
+	return map[string]string{packVersionLabel: Version}
+}
+
+// envList returns the user provided environment variables in KEY=VALUE
+// form, sorted by key.
+func (b *BuildConfig) envList() []string {
+	var env []string
+	for _, k := range sortedEnvKeys(b.Env) {
+		env = append(env, k+"="+b.Env[k])
+	}
+	return env
+}
+
+// platformEnvTar creates a tar containing one file per environment variable
+// in /workspace/platform/env, following the platform env convention of the
+// buildpack spec.
+func platformEnvTar(env map[string]string, uid, gid int) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, dir := range []string{"/workspace/platform", "/workspace/platform/env"} {
+		if err := tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755, Uid: uid, Gid: gid}); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range sortedEnvKeys(env) {
+		if err := tw.WriteHeader(&tar.Header{Name: "/workspace/platform/env/" + k, Size: int64(len(env[k])), Mode: 0644, Uid: uid, Gid: gid}); err != nil {
+			return nil, err
 		}
+		if _, err := tw.Write([]byte(env[k])); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
 
-		if err := exportDaemon(b.Cli, buildpacks, b.WorkspaceVolume, b.RepoName, b.RunImage, b.Stdout); err != nil {
+func sortedEnvKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addEnv adds the environment variables from the env file and then from
+// the KEY=VALUE pairs, so that the latter take precedence. A KEY without a
+// value takes its value from the environment of pack itself.
+func addEnv(env map[string]string, envFile string, vars []string) error {
+	if envFile != "" {
+		f, err := os.Open(envFile)
+		if err != nil {
+			return errors.Wrap(err, "open env file")
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := addEnvVar(env, line); err != nil {
+				return errors.Wrapf(err, "parse env file %s", envFile)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return errors.Wrap(err, "read env file")
+		}
+	}
+	for _, v := range vars {
+		if err := addEnvVar(env, v); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
+func addEnvVar(env map[string]string, v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if kv[0] == "" || strings.ContainsAny(kv[0], "/ ") {
+		return fmt.Errorf(`invalid environment variable "%s"`, v)
+	}
+	if len(kv) == 2 {
+		env[kv[0]] = kv[1]
+	} else if val, ok := os.LookupEnv(kv[0]); ok {
+		env[kv[0]] = val
+	}
 	return nil
 }
 
 func (b *BuildConfig) imageLabel(repoName, key string, useDaemon bool) (string, error) {
-	var labels map[string]string
+	labels, _, err := imageLabels(b.Cli, b.Images, repoName, useDaemon)
+	if err != nil {
+		return "", err
+	}
+	return labels[key], nil
+}
+
+func imageLabels(cli Docker, images Images, repoName string, useDaemon bool) (map[string]string, bool, error) {
 	if useDaemon {
-		i, _, err := b.Cli.ImageInspectWithRaw(context.Background(), repoName)
+		i, _, err := cli.ImageInspectWithRaw(context.Background(), repoName)
 		if dockercli.IsErrNotFound(err) {
-			return "", nil
+			return nil, false, nil
 		} else if err != nil {
-			return "", errors.Wrap(err, "analyze read previous image config")
+			return nil, false, errors.Wrap(err, "analyze read previous image config")
 		}
-		labels = i.Config.Labels
-	} else {
-		origImage, err := b.Images.ReadImage(repoName, false)
-		if err != nil || origImage == nil {
-			return "", err
-		}
-		config, err := origImage.ConfigFile()
-		if err != nil {
-			if remoteErr, ok := err.(*remote.Error); ok && len(remoteErr.Errors) > 0 {
-				switch remoteErr.Errors[0].Code {
-				case remote.UnauthorizedErrorCode, remote.ManifestUnknownErrorCode:
-					return "", nil
-				}
+		return i.Config.Labels, true, nil
+	}
+
+	origImage, err := images.ReadImage(repoName, false)
+	if err != nil || origImage == nil {
+		return nil, false, err
+	}
+	config, err := origImage.ConfigFile()
+	if err != nil {
+		if remoteErr, ok := err.(*remote.Error); ok && len(remoteErr.Errors) > 0 {
+			switch remoteErr.Errors[0].Code {
+			case remote.UnauthorizedErrorCode, remote.ManifestUnknownErrorCode:
+				return nil, false, nil
 			}
-			return "", errors.Wrapf(err, "access manifest: %s", repoName)
 		}
-		labels = config.Config.Labels
+		return nil, false, errors.Wrapf(err, "access manifest: %s", repoName)
 	}
-
-	return labels[key], nil
+	return config.Config.Labels, true, nil
 }
 
 func (b *BuildConfig) packUidGid(builder string) (int, int, error) {
@@ -412,12 +771,12 @@ func (b *BuildConfig) packUidGid(builder string) (int, int, error) {
 	return uid, gid, nil
 }
 
-func (b *BuildConfig) chownDir(path string, uid, gid int) error {
-	ctx := context.Background()
+func (b *BuildConfig) chownDir(ctx context.Context, path string, uid, gid int) error {
 	ctr, err := b.Cli.ContainerCreate(ctx, &container.Config{
-		Image: b.Builder,
-		Cmd:   []string{"chown", "-R", fmt.Sprintf("%d:%d", uid, gid), path},
-		User:  "root",
+		Image:  b.Builder,
+		Labels: b.labels(),
+		Cmd:    []string{"chown", "-R", fmt.Sprintf("%d:%d", uid, gid), path},
+		User:   "root",
 	}, &container.HostConfig{
 		Binds: []string{
 			b.WorkspaceVolume + ":/workspace",
@@ -426,18 +785,21 @@ func (b *BuildConfig) chownDir(path string, uid, gid int) error {
 	if err != nil {
 		return err
 	}
-	defer b.Cli.ContainerRemove(ctx, ctr.ID, dockertypes.ContainerRemoveOptions{})
+	defer removeContainer(b.Cli, ctr.ID)
 	if err := b.Cli.RunContainer(ctx, ctr.ID, b.Stdout, b.Stderr); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (b *BuildConfig) exportVolume(image, volName string) (string, func(), error) {
-	ctx := context.Background()
+// workspaceContainer creates a container, which is never started, with the
+// workspace volume mounted read-only on /workspace to copy files out of it.
+// The returned function removes the container.
+func (b *BuildConfig) workspaceContainer(ctx context.Context) (string, func(), error) {
 	ctr, err := b.Cli.ContainerCreate(ctx, &container.Config{
-		Image: b.Builder,
-		Cmd:   []string{"true"},
+		Image:  b.Builder,
+		Labels: b.labels(),
+		Cmd:    []string{"true"},
 	}, &container.HostConfig{
 		Binds: []string{
 			b.WorkspaceVolume + ":/workspace:ro",
@@ -446,9 +808,17 @@ func (b *BuildConfig) exportVolume(image, volName string) (string, func(), error
 	if err != nil {
 		return "", func() {}, errors.Wrap(err, "export container create")
 	}
-	defer b.Cli.ContainerRemove(ctx, ctr.ID, dockertypes.ContainerRemoveOptions{})
+	return ctr.ID, func() { removeContainer(b.Cli, ctr.ID) }, nil
+}
 
-	r, _, err := b.Cli.CopyFromContainer(ctx, ctr.ID, "/workspace")
+func (b *BuildConfig) exportVolume(ctx context.Context, image, volName string) (string, func(), error) {
+	ctrID, removeCtr, err := b.workspaceContainer(ctx)
+	if err != nil {
+		return "", func() {}, err
+	}
+	defer removeCtr()
+
+	r, _, err := b.Cli.CopyFromContainer(ctx, ctrID, "/workspace")
 	if err != nil {
 		return "", func() {}, err
 	}
@@ -467,6 +837,14 @@ func (b *BuildConfig) exportVolume(image, volName string) (string, func(), error
 
 	return filepath.Join(tmpDir, "workspace"), cleanup, nil
 }
+
+// removeContainer force removes a container, stopping it if it is still
+// running. It does not take a context since it must also run after the build
+// is cancelled.
+func removeContainer(cli Docker, id string) error {
+	return cli.ContainerRemove(context.Background(), id, dockertypes.ContainerRemoveOptions{Force: true})
+}
+
 func randString(n int) string {
 	b := make([]byte, n)
 	for i := range b {
@@ -0,0 +1,180 @@
+package pack
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/packs"
+)
+
+// Types of the events emitted during a build.
+const (
+	EventPhaseStart = "phase-start"
+	EventPhaseEnd   = "phase-end"
+	EventDetected   = "detected"
+	EventRunImage   = "run-image"
+	EventLayer      = "layer"
+	EventImage      = "image"
+)
+
+// BuildEvent is emitted to BuildConfig.OnEvent as the build progresses. The
+// fields that are set depend on Type.
+type BuildEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Phase and Duration are set on phase-start and phase-end, Error on a
+	// phase-end of a failed phase
+	Phase    string  `json:"phase,omitempty"`
+	Duration float64 `json:"duration-seconds,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	// Buildpacks is set on detected
+	Buildpacks []BuilderBuildpack `json:"buildpacks,omitempty"`
+	// RunImage is set on run-image
+	RunImage string `json:"run-image,omitempty"`
+	// Layer is set on layer
+	Layer *BuildLayer `json:"layer,omitempty"`
+	// ImageID and Digest are set on image, Digest only when the image is
+	// not exported to the daemon
+	ImageID string `json:"image-id,omitempty"`
+	Digest  string `json:"digest,omitempty"`
+}
+
+// BuildResult is returned by Run and describes the build, even when it fails.
+type BuildResult struct {
+	RepoName string `json:"image"`
+	// ImageID is the ID of the image config, which is the image ID on the
+	// daemon
+	ImageID string `json:"image-id,omitempty"`
+	// Digest is the digest of the image manifest, which is empty for images
+	// exported to the daemon since it does not keep it
+	Digest   string                    `json:"digest,omitempty"`
+	RunImage string                    `json:"run-image"`
+	Group    *lifecycle.BuildpackGroup `json:"-"`
+	// Buildpacks are the buildpacks of Group
+	Buildpacks []BuilderBuildpack `json:"buildpacks"`
+	Layers     []BuildLayer       `json:"layers"`
+	Phases     []BuildPhase       `json:"phases"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// BuildLayer is a layer of the exported image. Name is app, config or
+// <buildpack ID>/<layer name>.
+type BuildLayer struct {
+	Name   string `json:"name"`
+	DiffID string `json:"diff-id"`
+	// Reused is true when the layer was taken from the previous image
+	Reused bool `json:"reused"`
+}
+
+type BuildPhase struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration-seconds"`
+}
+
+func newBuildResult(repoName string) *BuildResult {
+	return &BuildResult{
+		RepoName:   repoName,
+		Buildpacks: []BuilderBuildpack{},
+		Layers:     []BuildLayer{},
+		Phases:     []BuildPhase{},
+	}
+}
+
+// emit records event in the result of the running build, if any, and passes
+// it to OnEvent.
+func (b *BuildConfig) emit(event BuildEvent) {
+	event.Time = time.Now()
+	if r := b.result; r != nil {
+		switch event.Type {
+		case EventPhaseEnd:
+			r.Phases = append(r.Phases, BuildPhase{Name: event.Phase, Duration: event.Duration})
+		case EventDetected:
+			r.Buildpacks = event.Buildpacks
+		case EventRunImage:
+			r.RunImage = event.RunImage
+		case EventLayer:
+			r.Layers = append(r.Layers, *event.Layer)
+		case EventImage:
+			r.ImageID, r.Digest = event.ImageID, event.Digest
+		}
+	}
+	if b.OnEvent != nil {
+		b.OnEvent(event)
+	}
+}
+
+// phase runs one phase of the build between a phase-start and a phase-end
+// event.
+func (b *BuildConfig) phase(name string, run func() error) error {
+	b.emit(BuildEvent{Type: EventPhaseStart, Phase: name})
+	start := time.Now()
+	err := run()
+	event := BuildEvent{Type: EventPhaseEnd, Phase: name, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	b.emit(event)
+	return err
+}
+
+func (b *BuildConfig) emitDetected(group *lifecycle.BuildpackGroup) {
+	buildpacks := []BuilderBuildpack{}
+	for _, bp := range group.Buildpacks {
+		buildpacks = append(buildpacks, BuilderBuildpack{ID: bp.ID, Version: bp.Version})
+	}
+	if b.result != nil {
+		b.result.Group = group
+	}
+	b.emit(BuildEvent{Type: EventDetected, Buildpacks: buildpacks})
+}
+
+// emitLayers emits a layer event for each layer in the metadata of the new
+// image, in the order they were added. reused holds the names of the layers
+// that the exporter took from the previous image.
+func (b *BuildConfig) emitLayers(metadata packs.BuildMetadata, reused map[string]bool) {
+	add := func(name, sha string) {
+		b.emit(BuildEvent{Type: EventLayer, Layer: &BuildLayer{Name: name, DiffID: sha, Reused: reused[name]}})
+	}
+	add("app", metadata.App.SHA)
+	add("config", metadata.Config.SHA)
+	for _, bp := range metadata.Buildpacks {
+		var names []string
+		for name := range bp.Layers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(bp.Key+"/"+name, bp.Layers[name].SHA)
+		}
+	}
+}
+
+// reuseRecorder passes the output of an exporter through to out, recording
+// the layers it reports reusing from the previous image, in lines like
+// "reusing layer '<buildpack ID>/<layer name>' with diffID '<diffID>'".
+type reuseRecorder struct {
+	out    io.Writer
+	line   []byte
+	reused map[string]bool
+}
+
+func (r *reuseRecorder) Write(p []byte) (int, error) {
+	r.line = append(r.line, p...)
+	for {
+		i := bytes.IndexByte(r.line, '\n')
+		if i < 0 {
+			break
+		}
+		if m := reusingLayerRegexp.FindSubmatch(r.line[:i]); m != nil {
+			r.reused[string(m[1])] = true
+		}
+		r.line = r.line[i+1:]
+	}
+	return r.out.Write(p)
+}
+
+var reusingLayerRegexp = regexp.MustCompile(`^reusing layer '([^']+)'`)
@@ -2,7 +2,9 @@ package pack_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -24,6 +26,7 @@ import (
 	"github.com/buildpack/pack/mocks"
 	dockertypes "github.com/docker/docker/api/types"
 	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-containerregistry/pkg/v1"
@@ -61,7 +64,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 			Images:          &image.Client{},
 		}
 		log.SetOutput(ioutil.Discard)
-		subject.Cli, err = docker.New()
+		subject.Cli, err = docker.New("")
 		assertNil(t, err)
 	})
 
@@ -98,20 +101,20 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 		})
 
 		it("defaults to daemon, pulls builder and run images, selects run-image using builder's stack", func() {
-			mockDocker.EXPECT().PullImage("some/builder")
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/builder")
 			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(dockertypes.ImageInspect{
 				Config: &dockercontainer.Config{
 					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
 				},
 			}, nil, nil)
-			mockDocker.EXPECT().PullImage("some/run")
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/run")
 			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/run").Return(dockertypes.ImageInspect{
 				Config: &dockercontainer.Config{
 					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
 				},
 			}, nil, nil)
 
-			config, err := factory.BuildConfigFromFlags(&pack.BuildFlags{
+			config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
 				RepoName: "some/app",
 				Builder:  "some/builder",
 			})
@@ -120,20 +123,20 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 		})
 
 		it("selects run images with matching registry", func() {
-			mockDocker.EXPECT().PullImage("some/builder")
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/builder")
 			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(dockertypes.ImageInspect{
 				Config: &dockercontainer.Config{
 					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
 				},
 			}, nil, nil)
-			mockDocker.EXPECT().PullImage("registry.com/some/run")
+			mockDocker.EXPECT().PullImage(gomock.Any(), "registry.com/some/run")
 			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "registry.com/some/run").Return(dockertypes.ImageInspect{
 				Config: &dockercontainer.Config{
 					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
 				},
 			}, nil, nil)
 
-			config, err := factory.BuildConfigFromFlags(&pack.BuildFlags{
+			config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
 				RepoName: "registry.com/some/app",
 				Builder:  "some/builder",
 			})
@@ -142,7 +145,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 		})
 
 		it("doesn't pull run images when --publish is passed", func() {
-			mockDocker.EXPECT().PullImage("some/builder")
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/builder")
 			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(dockertypes.ImageInspect{
 				Config: &dockercontainer.Config{
 					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
@@ -158,7 +161,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 				},
 			}, nil)
 
-			config, err := factory.BuildConfigFromFlags(&pack.BuildFlags{
+			config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
 				RepoName: "some/app",
 				Builder:  "some/builder",
 				Publish:  true,
@@ -168,7 +171,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 		})
 
 		it("allows run-image from flags if the stacks match", func() {
-			mockDocker.EXPECT().PullImage("some/builder")
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/builder")
 			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(dockertypes.ImageInspect{
 				Config: &dockercontainer.Config{
 					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
@@ -184,7 +187,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 				},
 			}, nil)
 
-			config, err := factory.BuildConfigFromFlags(&pack.BuildFlags{
+			config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
 				RepoName: "some/app",
 				Builder:  "some/builder",
 				RunImage: "override/run",
@@ -195,7 +198,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 		})
 
 		it("doesn't allows run-image from flags if the stacks are difference", func() {
-			mockDocker.EXPECT().PullImage("some/builder")
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/builder")
 			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(dockertypes.ImageInspect{
 				Config: &dockercontainer.Config{
 					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
@@ -211,7 +214,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 				},
 			}, nil)
 
-			_, err := factory.BuildConfigFromFlags(&pack.BuildFlags{
+			_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
 				RepoName: "some/app",
 				Builder:  "some/builder",
 				RunImage: "override/run",
@@ -220,15 +223,329 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 			assertError(t, err, `invalid stack: stack "other.stack.id" from run image "override/run" does not match stack "some.stack.id" from builder image "some/builder"`)
 		})
 
+		when("pull policy", func() {
+			stackLabels := dockertypes.ImageInspect{
+				Config: &dockercontainer.Config{
+					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+				},
+			}
+
+			it("only pulls images that are not on the daemon with if-not-present", func() {
+				mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(stackLabels, nil, nil).Times(2)
+				gomock.InOrder(
+					mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/run").Return(dockertypes.ImageInspect{}, nil, notFoundError{}),
+					mockDocker.EXPECT().PullImage(gomock.Any(), "some/run"),
+					mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/run").Return(stackLabels, nil, nil),
+				)
+
+				_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName:   "some/app",
+					Builder:    "some/builder",
+					PullPolicy: "if-not-present",
+				})
+				assertNil(t, err)
+			})
+
+			it("doesn't pull images pinned by digest that are on the daemon", func() {
+				builder := "some/builder@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+				mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), builder).Return(stackLabels, nil, nil).Times(2)
+				mockDocker.EXPECT().PullImage(gomock.Any(), "some/run")
+				mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/run").Return(stackLabels, nil, nil)
+
+				_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName: "some/app",
+					Builder:  builder,
+				})
+				assertNil(t, err)
+			})
+
+			it("uses the default pull policy from the config", func() {
+				factory.Config.DefaultPullPolicy = "never"
+				mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(stackLabels, nil, nil)
+				mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/run").Return(stackLabels, nil, nil)
+
+				_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName: "some/app",
+					Builder:  "some/builder",
+				})
+				assertNil(t, err)
+			})
+
+			it("errors on an unknown pull policy", func() {
+				_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName:   "some/app",
+					Builder:    "some/builder",
+					PullPolicy: "sometimes",
+				})
+				assertError(t, err, `invalid pull policy "sometimes": must be one of "always", "if-not-present" or "never"`)
+			})
+		})
+
+		it("reads environment variables from the env file and flags, with flags taking precedence", func() {
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/builder")
+			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(dockertypes.ImageInspect{
+				Config: &dockercontainer.Config{
+					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+				},
+			}, nil, nil)
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/run")
+			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/run").Return(dockertypes.ImageInspect{
+				Config: &dockercontainer.Config{
+					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+				},
+			}, nil, nil)
+
+			tmpDir, err := ioutil.TempDir("", "pack.build.env.")
+			assertNil(t, err)
+			defer os.RemoveAll(tmpDir)
+			envFile := filepath.Join(tmpDir, "env")
+			assertNil(t, ioutil.WriteFile(envFile, []byte("# comment\nVAR1=from-file\n\nVAR2=from-file=with-equals\n"), 0666))
+			os.Setenv("PACK_TEST_BUILD_ENV", "from-environment")
+			defer os.Unsetenv("PACK_TEST_BUILD_ENV")
+
+			config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+				RepoName: "some/app",
+				Builder:  "some/builder",
+				EnvFile:  envFile,
+				Env:      []string{"VAR1=from-flag", "PACK_TEST_BUILD_ENV", "PACK_TEST_UNSET_ENV"},
+			})
+			assertNil(t, err)
+			assertEq(t, config.Env, map[string]string{
+				"VAR1":                "from-flag",
+				"VAR2":                "from-file=with-equals",
+				"PACK_TEST_BUILD_ENV": "from-environment",
+			})
+		})
+
+		when("the app dir has a project descriptor", func() {
+			var appDir string
+			it.Before(func() {
+				var err error
+				appDir, err = ioutil.TempDir("", "pack.build.descriptor.")
+				assertNil(t, err)
+				assertNil(t, ioutil.WriteFile(filepath.Join(appDir, "project.toml"), []byte(`
+image = "descriptor/app"
+builder = "descriptor/builder"
+run-image = "descriptor/run"
+publish = true
+env-file = "build.env"
+exclude = ["tmp/"]
+
+[env]
+VAR1 = "from-descriptor"
+VAR2 = "from-descriptor"
+`), 0666))
+				assertNil(t, ioutil.WriteFile(filepath.Join(appDir, "build.env"), []byte("VAR1=from-file\nVAR3=from-file\n"), 0666))
+				assertNil(t, ioutil.WriteFile(filepath.Join(appDir, ".packignore"), []byte(".git\nnode_modules/\n"), 0666))
+
+				mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), gomock.Any()).Return(dockertypes.ImageInspect{
+					Config: &dockercontainer.Config{
+						Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+					},
+				}, nil, nil).AnyTimes()
+				mockRunImage := mocks.NewMockImage(mockController)
+				mockImages.EXPECT().ReadImage(gomock.Any(), false).Return(mockRunImage, nil).AnyTimes()
+				mockRunImage.EXPECT().ConfigFile().Return(&v1.ConfigFile{
+					Config: v1.Config{
+						Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+					},
+				}, nil).AnyTimes()
+			})
+			it.After(func() { os.RemoveAll(appDir) })
+
+			it("uses the descriptor for the flags that are not provided", func() {
+				mockDocker.EXPECT().PullImage(gomock.Any(), "descriptor/builder")
+
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					AppDir: appDir,
+				})
+				assertNil(t, err)
+				assertEq(t, config.RepoName, "descriptor/app")
+				assertEq(t, config.Builder, "descriptor/builder")
+				assertEq(t, config.RunImage, "descriptor/run")
+				assertEq(t, config.Publish, true)
+				assertEq(t, config.Env, map[string]string{
+					"VAR1": "from-descriptor",
+					"VAR2": "from-descriptor",
+					"VAR3": "from-file",
+				})
+			})
+
+			it("gives precedence to the provided flags", func() {
+				mockDocker.EXPECT().PullImage(gomock.Any(), "flag/builder")
+
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					AppDir:   appDir,
+					RepoName: "flag/app",
+					Builder:  "flag/builder",
+					RunImage: "flag/run",
+					Env:      []string{"VAR2=from-flag"},
+					Exclude:  []string{"*.log"},
+				})
+				assertNil(t, err)
+				assertEq(t, config.Exclude, []string{".git", "node_modules/", "tmp/", "*.log"})
+				assertEq(t, config.RepoName, "flag/app")
+				assertEq(t, config.Builder, "flag/builder")
+				assertEq(t, config.RunImage, "flag/run")
+				assertEq(t, config.Env["VAR2"], "from-flag")
+			})
+
+			it("gives precedence to an explicit --publish=false", func() {
+				mockDocker.EXPECT().PullImage(gomock.Any(), "descriptor/builder")
+				mockDocker.EXPECT().PullImage(gomock.Any(), "descriptor/run")
+
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					AppDir:     appDir,
+					Publish:    false,
+					PublishSet: true,
+				})
+				assertNil(t, err)
+				assertEq(t, config.Publish, false)
+			})
+
+			it("reads the descriptor from a non-default location", func() {
+				assertNil(t, os.Rename(filepath.Join(appDir, "project.toml"), filepath.Join(appDir, "other.toml")))
+				mockDocker.EXPECT().PullImage(gomock.Any(), "descriptor/builder")
+
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					AppDir:     appDir,
+					Descriptor: filepath.Join(appDir, "other.toml"),
+				})
+				assertNil(t, err)
+				assertEq(t, config.RepoName, "descriptor/app")
+			})
+
+			it("errors when a provided descriptor is missing", func() {
+				_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					AppDir:     appDir,
+					Descriptor: filepath.Join(appDir, "missing.toml"),
+				})
+				assertNotNil(t, err)
+				assertContains(t, err.Error(), "read project descriptor")
+			})
+		})
+
+		it("errors when no image name is provided", func() {
+			_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+				Builder: "some/builder",
+			})
+			assertError(t, err, "missing image name: provide one as an argument or as 'image' in the project descriptor")
+		})
+
+		it("errors when more than one destination is provided", func() {
+			_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+				RepoName:  "some/app",
+				Builder:   "some/builder",
+				OutputOCI: "some/dir",
+				OutputTar: "some/image.tar",
+			})
+			assertError(t, err, "only one of --publish, --output-oci and --output-tar may be used")
+		})
+
+		when("a cache key is provided", func() {
+			it.Before(func() {
+				mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), gomock.Any()).Return(dockertypes.ImageInspect{
+					Config: &dockercontainer.Config{
+						Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+					},
+				}, nil, nil).AnyTimes()
+			})
+
+			it("names the cache volume after the key instead of the app dir", func() {
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName:   "some/app",
+					Builder:    "some/builder",
+					PullPolicy: "never",
+					CacheKey:   "some-key",
+				})
+				assertNil(t, err)
+				assertEq(t, config.CacheKey, "some-key")
+				assertEq(t, config.CacheVolume, pack.CacheVolumeName("some-key"))
+			})
+
+			it("derives the key from the image repository", func() {
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName:          "registry.com/some/app:some-tag",
+					Builder:           "some/builder",
+					RunImage:          "some/run",
+					PullPolicy:        "never",
+					CacheKeyFromImage: true,
+				})
+				assertNil(t, err)
+				assertEq(t, config.CacheKey, "registry.com/some/app")
+			})
+
+			it("errors when both a key and the image are requested", func() {
+				_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName:          "some/app",
+					Builder:           "some/builder",
+					PullPolicy:        "never",
+					CacheKey:          "some-key",
+					CacheKeyFromImage: true,
+				})
+				assertError(t, err, "cache-key and cache-key-from-image cannot be used together")
+			})
+		})
+
+		when("no builder is provided", func() {
+			it.Before(func() {
+				mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), gomock.Any()).Return(dockertypes.ImageInspect{
+					Config: &dockercontainer.Config{
+						Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+					},
+				}, nil, nil).AnyTimes()
+				factory.Config.DefaultStackID = "some.stack.id"
+			})
+
+			it("uses the default builder of the default stack over the one from the config", func() {
+				factory.Config.DefaultBuilder = "config/builder"
+				factory.Config.Stacks[0].DefaultBuilder = "stack/builder"
+				mockDocker.EXPECT().PullImage(gomock.Any(), "stack/builder")
+				mockDocker.EXPECT().PullImage(gomock.Any(), "some/run")
+
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName: "some/app",
+				})
+				assertNil(t, err)
+				assertEq(t, config.Builder, "stack/builder")
+				assertContains(t, buf.String(), "Using default builder 'stack/builder' from default stack 'some.stack.id'")
+			})
+
+			it("uses the default builder from the config when the default stack has none", func() {
+				factory.Config.DefaultBuilder = "config/builder"
+				mockDocker.EXPECT().PullImage(gomock.Any(), "config/builder")
+				mockDocker.EXPECT().PullImage(gomock.Any(), "some/run")
+
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName: "some/app",
+				})
+				assertNil(t, err)
+				assertEq(t, config.Builder, "config/builder")
+				assertContains(t, buf.String(), "Using default builder 'config/builder' from pack config")
+			})
+
+			it("falls back to the built-in default builder", func() {
+				mockDocker.EXPECT().PullImage(gomock.Any(), "packs/samples")
+				mockDocker.EXPECT().PullImage(gomock.Any(), "some/run")
+
+				config, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
+					RepoName: "some/app",
+				})
+				assertNil(t, err)
+				assertEq(t, config.Builder, "packs/samples")
+				assertContains(t, buf.String(), "Using built-in default builder 'packs/samples'")
+			})
+		})
+
 		it("returns an errors when the builder stack label is missing", func() {
-			mockDocker.EXPECT().PullImage("some/builder")
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/builder")
 			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/builder").Return(dockertypes.ImageInspect{
 				Config: &dockercontainer.Config{
 					Labels: map[string]string{},
 				},
 			}, nil, nil)
 
-			_, err := factory.BuildConfigFromFlags(&pack.BuildFlags{
+			_, err := factory.BuildConfigFromFlags(context.TODO(), &pack.BuildFlags{
 				RepoName: "some/app",
 				Builder:  "some/builder",
 			})
@@ -236,9 +553,72 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Run", func() {
+		var mockController *gomock.Controller
+
+		it.Before(func() {
+			mockController = gomock.NewController(t)
+		})
+
+		it.After(func() {
+			mockController.Finish()
+		})
+
+		when("the cache is cleared", func() {
+			it("removes the cache volume before creating it again", func() {
+				mockDocker := mocks.NewMockDocker(mockController)
+				subject.Cli = mockDocker
+				subject.ClearCache = true
+
+				gomock.InOrder(
+					mockDocker.EXPECT().VolumeCreate(gomock.Any(), gomock.Any()),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), subject.CacheVolume, true),
+					mockDocker.EXPECT().VolumeInspect(gomock.Any(), subject.CacheVolume).Return(dockertypes.Volume{}, notFoundError{}),
+					mockDocker.EXPECT().VolumeCreate(gomock.Any(), gomock.Any()).Do(func(_ context.Context, options volume.VolumeCreateBody) {
+						assertEq(t, options.Name, subject.CacheVolume)
+					}),
+					mockDocker.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), nil, "").Return(dockercontainer.ContainerCreateCreatedBody{}, errors.New("some-error")),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), subject.WorkspaceVolume, true),
+				)
+
+				result, err := subject.Run(context.TODO())
+				assertError(t, err, "container create: some-error")
+				assertEq(t, result.Error, "container create: some-error")
+			})
+		})
+	})
+
+	when("#Build", func() {
+		var mockController *gomock.Controller
+
+		it.Before(func() {
+			mockController = gomock.NewController(t)
+		})
+
+		it.After(func() {
+			mockController.Finish()
+		})
+
+		when("the context is cancelled", func() {
+			it("force removes the build container", func() {
+				mockDocker := mocks.NewMockDocker(mockController)
+				subject.Cli = mockDocker
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				mockDocker.EXPECT().ContainerCreate(ctx, gomock.Any(), gomock.Any(), nil, "").Return(dockercontainer.ContainerCreateCreatedBody{ID: "some-id"}, nil)
+				mockDocker.EXPECT().CopyToContainer(ctx, "some-id", "/", gomock.Any(), gomock.Any())
+				mockDocker.EXPECT().RunContainer(ctx, "some-id", gomock.Any(), gomock.Any()).Return(context.Canceled)
+				mockDocker.EXPECT().ContainerRemove(gomock.Not(ctx), "some-id", dockertypes.ContainerRemoveOptions{Force: true})
+
+				assertError(t, subject.Build(ctx), "context canceled")
+			})
+		})
+	})
+
 	when("#Detect", func() {
 		it("copies the app in to docker and chowns it (including directories)", func() {
-			_, err := subject.Detect()
+			_, err := subject.Detect(context.TODO())
 			assertNil(t, err)
 
 			for _, name := range []string{"/workspace/app", "/workspace/app/app.js", "/workspace/app/mydir", "/workspace/app/mydir/myfile.txt"} {
@@ -248,9 +628,18 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 
+		it("writes the environment variables to the platform env dir", func() {
+			subject.Env = map[string]string{"VAR1": "value1", "VAR2": "value2"}
+			_, err := subject.Detect(context.TODO())
+			assertNil(t, err)
+
+			assertEq(t, readFromDocker(t, subject.WorkspaceVolume, "/workspace/platform/env/VAR1"), "value1")
+			assertEq(t, readFromDocker(t, subject.WorkspaceVolume, "/workspace/platform/env/VAR2"), "value2")
+		})
+
 		when("app is detected", func() {
 			it("returns the successful group with node", func() {
-				group, err := subject.Detect()
+				group, err := subject.Detect(context.TODO())
 				assertNil(t, err)
 				assertEq(t, group.Buildpacks[0].ID, "io.buildpacks.samples.nodejs")
 			})
@@ -267,7 +656,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 			})
 			it.After(func() { os.RemoveAll(badappDir) })
 			it("returns the successful group with node", func() {
-				_, err := subject.Detect()
+				_, err := subject.Detect(context.TODO())
 
 				assertNotNil(t, err)
 				assertEq(t, err.Error(), "run detect container: failed with status code: 6")
@@ -298,7 +687,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 				it.After(func() { assertNil(t, exec.Command("docker", "kill", registryContainerName).Run()) })
 
 				it("informs the user", func() {
-					err := subject.Analyze()
+					err := subject.Analyze(context.TODO())
 					assertNil(t, err)
 					assertContains(t, buf.String(), "WARNING: skipping analyze, image not found or requires authentication to access")
 				})
@@ -306,7 +695,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 			when("daemon", func() {
 				it.Before(func() { subject.Publish = false })
 				it("informs the user", func() {
-					err := subject.Analyze()
+					err := subject.Analyze(context.TODO())
 					assertNil(t, err)
 					assertContains(t, buf.String(), "WARNING: skipping analyze, image not found\n")
 				})
@@ -340,14 +729,14 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 				})
 
 				it("tells the user nothing", func() {
-					assertNil(t, subject.Analyze())
+					assertNil(t, subject.Analyze(context.TODO()))
 
 					txt := string(bytes.Trim(buf.Bytes(), "\x00"))
 					assertEq(t, txt, "")
 				})
 
 				it("places files in workspace", func() {
-					assertNil(t, subject.Analyze())
+					assertNil(t, subject.Analyze(context.TODO()))
 
 					txt := readFromDocker(t, subject.WorkspaceVolume, "/workspace/io.buildpacks.samples.nodejs/node_modules.toml")
 
@@ -359,14 +748,14 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 				it.Before(func() { subject.Publish = false })
 
 				it("tells the user nothing", func() {
-					assertNil(t, subject.Analyze())
+					assertNil(t, subject.Analyze(context.TODO()))
 
 					txt := string(bytes.Trim(buf.Bytes(), "\x00"))
 					assertEq(t, txt, "")
 				})
 
 				it("places files in workspace", func() {
-					assertNil(t, subject.Analyze())
+					assertNil(t, subject.Analyze(context.TODO()))
 
 					txt := readFromDocker(t, subject.WorkspaceVolume, "/workspace/io.buildpacks.samples.nodejs/node_modules.toml")
 					assertEq(t, txt, "lock_checksum = \"eb04ed1b461f1812f0f4233ef997cdb5\"\n")
@@ -417,12 +806,12 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 					assertNil(t, exec.Command("docker", "kill", registryContainerName).Run())
 				})
 				it("creates the image on the registry", func() {
-					assertNil(t, subject.Export(group))
+					assertNil(t, subject.Export(context.TODO(), group))
 					images := httpGet(t, "http://localhost:"+registryPort+"/v2/_catalog")
 					assertContains(t, images, oldRepoName)
 				})
 				it("puts the files on the image", func() {
-					assertNil(t, subject.Export(group))
+					assertNil(t, subject.Export(context.TODO(), group))
 
 					assertNil(t, exec.Command("docker", "pull", subject.RepoName).Run())
 					txt, err := exec.Command("docker", "run", subject.RepoName, "cat", "/workspace/app/file.txt").Output()
@@ -434,7 +823,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 					assertEq(t, string(txt), "content")
 				})
 				it("sets the metadata on the image", func() {
-					assertNil(t, subject.Export(group))
+					assertNil(t, subject.Export(context.TODO(), group))
 
 					assertNil(t, exec.Command("docker", "pull", subject.RepoName).Run())
 					var metadata lifecycle.AppImageMetadata
@@ -449,18 +838,38 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 					assertEq(t, metadata.Buildpacks[0].Layers["mylayer"].Data, map[string]interface{}{"key": "myval"})
 					assertContains(t, metadata.Buildpacks[0].Layers["other"].SHA, "sha256:")
 				})
+				it("reports the upload progress of each layer", func() {
+					assertNil(t, subject.Export(context.TODO(), group))
+
+					assertContains(t, buf.String(), "uploaded layer 'app'")
+					assertContains(t, buf.String(), "uploaded layer 'config'")
+					assertContains(t, buf.String(), "uploaded layer 'io.buildpacks.samples.nodejs/mylayer'")
+				})
+				it("reuses layers from the previous image", func() {
+					assertNil(t, subject.Export(context.TODO(), group))
+					assertNil(t, exec.Command("docker", "run", "--user=root", "-v", subject.WorkspaceVolume+":/workspace", "packs/samples", "rm", "-rf", "/workspace/io.buildpacks.samples.nodejs/mylayer").Run())
+
+					buf.Reset()
+					assertNil(t, subject.Export(context.TODO(), group))
+					assertContains(t, buf.String(), "reusing layer 'io.buildpacks.samples.nodejs/mylayer'")
+
+					assertNil(t, exec.Command("docker", "pull", subject.RepoName).Run())
+					txt, err := exec.Command("docker", "run", subject.RepoName, "cat", "/workspace/io.buildpacks.samples.nodejs/mylayer/file.txt").Output()
+					assertNil(t, err)
+					assertEq(t, string(txt), "content")
+				})
 			})
 
 			when("daemon", func() {
 				it.Before(func() { subject.Publish = false })
 				it("creates the image on the daemon", func() {
-					assertNil(t, subject.Export(group))
+					assertNil(t, subject.Export(context.TODO(), group))
 					images, err := exec.Command("docker", "images", "--format", "{{.Repository}}:{{.Tag}}").Output()
 					assertNil(t, err)
 					assertContains(t, string(images), subject.RepoName)
 				})
 				it("puts the files on the image", func() {
-					assertNil(t, subject.Export(group))
+					assertNil(t, subject.Export(context.TODO(), group))
 
 					txt, err := exec.Command("docker", "run", subject.RepoName, "cat", "/workspace/app/file.txt").Output()
 					assertNil(t, err)
@@ -471,7 +880,7 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 					assertEq(t, string(txt), "content")
 				})
 				it("sets the metadata on the image", func() {
-					assertNil(t, subject.Export(group))
+					assertNil(t, subject.Export(context.TODO(), group))
 
 					var metadata lifecycle.AppImageMetadata
 					metadataJSON, err := exec.Command("docker", "inspect", subject.RepoName, "--format", `{{index .Config.Labels "io.buildpacks.lifecycle.metadata"}}`).Output()
@@ -479,6 +888,9 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 					assertNil(t, json.Unmarshal(metadataJSON, &metadata))
 
 					assertEq(t, metadata.RunImage.Name, "packs/run")
+					labels, err := exec.Command("docker", "inspect", subject.RepoName, "--format", `{{index .Config.Labels "io.buildpacks.pack.version"}},{{index .Config.Labels "io.buildpacks.pack.build-id"}}`).Output()
+					assertNil(t, err)
+					assertEq(t, strings.TrimSpace(string(labels)), pack.Version+",")
 					assertContains(t, metadata.App.SHA, "sha256:")
 					assertContains(t, metadata.Config.SHA, "sha256:")
 					assertEq(t, len(metadata.Buildpacks), 1)
@@ -486,25 +898,74 @@ func testBuild(t *testing.T, when spec.G, it spec.S) {
 					assertEq(t, metadata.Buildpacks[0].Layers["mylayer"].Data, map[string]interface{}{"key": "myval"})
 					assertContains(t, metadata.Buildpacks[0].Layers["other"].SHA, "sha256:")
 				})
+				it("writes the image ID to the digest file", func() {
+					tmpDir, err := ioutil.TempDir("", "pack.build.export.iid.")
+					assertNil(t, err)
+					defer os.RemoveAll(tmpDir)
+					subject.DigestFile = filepath.Join(tmpDir, "iid")
+
+					assertNil(t, subject.Export(context.TODO(), group))
+
+					imageID, err := exec.Command("docker", "inspect", subject.RepoName, "--format", "{{.Id}}").Output()
+					assertNil(t, err)
+					txt, err := ioutil.ReadFile(subject.DigestFile)
+					assertNil(t, err)
+					assertEq(t, string(txt), strings.TrimSpace(string(imageID)))
+				})
 			})
 		})
 
 		when("previous image exists", func() {
 			it("reuses images from previous layers", func() {
-				addLayer := "ADD --chown=pack:pack /workspace/io.buildpacks.samples.nodejs/mylayer /workspace/io.buildpacks.samples.nodejs/mylayer"
-				copyLayer := "COPY --from=prev --chown=pack:pack /workspace/io.buildpacks.samples.nodejs/mylayer /workspace/io.buildpacks.samples.nodejs/mylayer"
+				layerSHA := func() string {
+					var metadata lifecycle.AppImageMetadata
+					metadataJSON, err := exec.Command("docker", "inspect", subject.RepoName, "--format", `{{index .Config.Labels "io.buildpacks.lifecycle.metadata"}}`).Output()
+					assertNil(t, err)
+					assertNil(t, json.Unmarshal(metadataJSON, &metadata))
+					return metadata.Buildpacks[0].Layers["mylayer"].SHA
+				}
 
-				t.Log("create image and assert add new layer")
-				assertNil(t, subject.Export(group))
-				assertContains(t, buf.String(), addLayer)
+				t.Log("create image with a new layer")
+				assertNil(t, subject.Export(context.TODO(), group))
+				origSHA := layerSHA()
+				assertContains(t, origSHA, "sha256:")
 
 				t.Log("setup workspace to reuse layer")
-				buf.Reset()
 				assertNil(t, exec.Command("docker", "run", "--user=root", "-v", subject.WorkspaceVolume+":/workspace", "packs/samples", "rm", "-rf", "/workspace/io.buildpacks.samples.nodejs/mylayer").Run())
 
-				t.Log("recreate image and assert copying layer from previous image")
-				assertNil(t, subject.Export(group))
-				assertContains(t, buf.String(), copyLayer)
+				t.Log("recreate image and assert the layer from the previous image is reused")
+				assertNil(t, subject.Export(context.TODO(), group))
+				assertEq(t, layerSHA(), origSHA)
+				txt, err := exec.Command("docker", "run", subject.RepoName, "cat", "/workspace/io.buildpacks.samples.nodejs/mylayer/file.txt").Output()
+				assertNil(t, err)
+				assertEq(t, string(txt), "content")
+			})
+
+			it("emits the reused and new layers and the image ID", func() {
+				assertNil(t, subject.Export(context.TODO(), group))
+				assertNil(t, exec.Command("docker", "run", "--user=root", "-v", subject.WorkspaceVolume+":/workspace", "packs/samples", "rm", "-rf", "/workspace/io.buildpacks.samples.nodejs/mylayer").Run())
+
+				var events []pack.BuildEvent
+				subject.OnEvent = func(event pack.BuildEvent) { events = append(events, event) }
+				assertNil(t, subject.Export(context.TODO(), group))
+
+				var names []string
+				reused := map[string]bool{}
+				for _, event := range events[:len(events)-1] {
+					assertEq(t, event.Type, pack.EventLayer)
+					assertContains(t, event.Layer.DiffID, "sha256:")
+					names = append(names, event.Layer.Name)
+					reused[event.Layer.Name] = event.Layer.Reused
+				}
+				assertEq(t, names, []string{"app", "config", "io.buildpacks.samples.nodejs/mylayer", "io.buildpacks.samples.nodejs/other"})
+				assertEq(t, reused["io.buildpacks.samples.nodejs/mylayer"], true)
+
+				event := events[len(events)-1]
+				assertEq(t, event.Type, pack.EventImage)
+				imageID, err := exec.Command("docker", "inspect", subject.RepoName, "--format", "{{.Id}}").Output()
+				assertNil(t, err)
+				assertEq(t, event.ImageID, strings.TrimSpace(string(imageID)))
+				assertEq(t, event.Digest, "")
 			})
 		})
 	})
@@ -0,0 +1,435 @@
+package pack
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	dockercli "github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	cacheVolumePrefix   = "pack-cache-"
+	cacheImportPrefix   = "pack-cache-import-"
+	cacheBackupPrefix   = "pack-cache-backup-"
+	cacheLastUsedFile   = ".pack-last-used"
+	cacheAppPathLabel   = "io.buildpacks.pack.cache.app-path"
+	cacheKeyLabel       = "io.buildpacks.pack.cache.key"
+	cacheVolumeSizeNone = -1
+)
+
+type CacheVolume struct {
+	Name      string    `json:"name"`
+	Key       string    `json:"key"`
+	AppPath   string    `json:"app-path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created-at"`
+}
+
+type Cache struct {
+	Cli Docker
+	Log *log.Logger
+	// Image is used to create the helper containers that read and write the
+	// contents of cache volumes
+	Image string
+}
+
+// CacheVolumeName returns the name of the volume holding the cache for key.
+// Builds without an explicit cache key use the absolute app path as key.
+func CacheVolumeName(key string) string {
+	return fmt.Sprintf("%s%x", cacheVolumePrefix, md5.Sum([]byte(key)))
+}
+
+// List returns the cache volumes, sorted by key. Size is -1 when the daemon
+// does not report it.
+func (c *Cache) List() ([]CacheVolume, error) {
+	ctx := context.Background()
+	res, err := c.Cli.VolumeList(ctx, filters.NewArgs(filters.Arg("name", cacheVolumePrefix)))
+	if err != nil {
+		return nil, errors.Wrap(err, "list volumes")
+	}
+	usage, err := c.Cli.DiskUsage(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "read disk usage")
+	}
+	sizes := map[string]int64{}
+	for _, v := range usage.Volumes {
+		if v.UsageData != nil {
+			sizes[v.Name] = v.UsageData.Size
+		}
+	}
+
+	var volumes []CacheVolume
+	for _, v := range res.Volumes {
+		if !strings.HasPrefix(v.Name, cacheVolumePrefix) || strings.HasPrefix(v.Name, cacheImportPrefix) || strings.HasPrefix(v.Name, cacheBackupPrefix) {
+			continue
+		}
+		size, ok := sizes[v.Name]
+		if !ok {
+			size = cacheVolumeSizeNone
+		}
+		createdAt, _ := time.Parse(time.RFC3339, v.CreatedAt)
+		key, ok := v.Labels[cacheKeyLabel]
+		if !ok {
+			key = v.Labels[cacheAppPathLabel]
+		}
+		volumes = append(volumes, CacheVolume{
+			Name:      v.Name,
+			Key:       key,
+			AppPath:   v.Labels[cacheAppPathLabel],
+			Size:      size,
+			CreatedAt: createdAt,
+		})
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		if volumes[i].Key == volumes[j].Key {
+			return volumes[i].Name < volumes[j].Name
+		}
+		return volumes[i].Key < volumes[j].Key
+	})
+	return volumes, nil
+}
+
+func (c *Cache) Remove(key string) error {
+	name := CacheVolumeName(key)
+	if err := c.Cli.VolumeRemove(context.Background(), name, false); err != nil {
+		if dockercli.IsErrNotFound(err) {
+			return fmt.Errorf(`no cache found for "%s"`, key)
+		}
+		return errors.Wrapf(err, "remove cache volume %s", name)
+	}
+	return nil
+}
+
+// Prune removes the cache volumes last used by a build before the given age
+// and returns them. Volumes that were never used by a build since they
+// were created are aged from their creation. With dryRun it only returns
+// them.
+func (c *Cache) Prune(olderThan time.Duration, dryRun bool) ([]CacheVolume, error) {
+	volumes, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var candidates []CacheVolume
+	for _, v := range volumes {
+		if !v.CreatedAt.IsZero() && v.CreatedAt.Before(cutoff) {
+			candidates = append(candidates, v)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	lastUsed, err := c.lastUsed(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []CacheVolume
+	for _, v := range candidates {
+		if lastUsed[v.Name].After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := c.Cli.VolumeRemove(context.Background(), v.Name, false); err != nil {
+				return pruned, errors.Wrapf(err, "remove cache volume %s", v.Name)
+			}
+		}
+		pruned = append(pruned, v)
+	}
+	return pruned, nil
+}
+
+// lastUsed reads the time each volume was last used by a build, recorded by
+// markCacheUsed. Volumes without a record are left out.
+func (c *Cache) lastUsed(volumes []CacheVolume) (map[string]time.Time, error) {
+	ctx := context.Background()
+	var binds []string
+	for _, v := range volumes {
+		binds = append(binds, v.Name+":/caches/"+v.Name+":ro")
+	}
+	ctrID, err := c.helperContainer([]string{"true"}, binds...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Cli.ContainerRemove(ctx, ctrID, dockertypes.ContainerRemoveOptions{})
+
+	lastUsed := map[string]time.Time{}
+	for _, v := range volumes {
+		rc, _, err := c.Cli.CopyFromContainer(ctx, ctrID, "/caches/"+v.Name+"/"+cacheLastUsedFile)
+		if dockercli.IsErrNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "read last use of cache volume %s", v.Name)
+		}
+		t, err := readLastUsed(rc)
+		rc.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "read last use of cache volume %s", v.Name)
+		}
+		lastUsed[v.Name] = t
+	}
+	return lastUsed, nil
+}
+
+func readLastUsed(r io.Reader) (time.Time, error) {
+	tr := tar.NewReader(r)
+	if _, err := tr.Next(); err != nil {
+		return time.Time{}, err
+	}
+	b, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+}
+
+// markCacheUsed records the current time in the cache volume mounted on
+// /cache in the container, so that Prune keeps caches that are still used.
+// The container may be created but not started.
+func markCacheUsed(ctx context.Context, cli Docker, ctrID string) error {
+	now := []byte(time.Now().UTC().Format(time.RFC3339))
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "cache/" + cacheLastUsedFile, Mode: 0644, Size: int64(len(now)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(now); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := cli.CopyToContainer(ctx, ctrID, "/", &buf, dockertypes.CopyToContainerOptions{}); err != nil {
+		return errors.Wrap(err, "mark cache volume used")
+	}
+	return nil
+}
+
+// Export writes the contents of the cache volume for key to w as a tar
+// archive with entries under "cache/".
+func (c *Cache) Export(key string, w io.Writer) error {
+	ctx := context.Background()
+	name := CacheVolumeName(key)
+	if _, err := c.Cli.VolumeInspect(ctx, name); err != nil {
+		if dockercli.IsErrNotFound(err) {
+			return fmt.Errorf(`no cache found for "%s"`, key)
+		}
+		return errors.Wrapf(err, "inspect cache volume %s", name)
+	}
+
+	ctrID, err := c.helperContainer([]string{"true"}, name+":/cache:ro")
+	if err != nil {
+		return err
+	}
+	defer c.Cli.ContainerRemove(ctx, ctrID, dockertypes.ContainerRemoveOptions{})
+
+	r, _, err := c.Cli.CopyFromContainer(ctx, ctrID, "/cache")
+	if err != nil {
+		return errors.Wrap(err, "copy from cache volume")
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Import replaces the cache volume for key with the contents of a tar archive
+// created by Export. appDir is recorded on the volume when not empty. The
+// archive is checked and copied to a temporary volume first. Docker cannot
+// rename volumes, so the existing cache is then copied to a backup volume
+// before it is replaced, and restored from it when the import fails.
+func (c *Cache) Import(key, appDir string, r io.Reader) error {
+	ctx := context.Background()
+	name := CacheVolumeName(key)
+
+	archive, err := ioutil.TempFile("", "pack.cache.import.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	if err := checkCacheArchive(io.TeeReader(r, archive)); err != nil {
+		return err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmpName := cacheImportPrefix + strings.TrimPrefix(name, cacheVolumePrefix)
+	if err := c.createTmpVolume(ctx, tmpName); err != nil {
+		return err
+	}
+	defer c.Cli.VolumeRemove(ctx, tmpName, true)
+
+	ctrID, err := c.helperContainer([]string{"true"}, tmpName+":/cache")
+	if err != nil {
+		return err
+	}
+	err = c.Cli.CopyToContainer(ctx, ctrID, "/", archive, dockertypes.CopyToContainerOptions{})
+	c.Cli.ContainerRemove(ctx, ctrID, dockertypes.ContainerRemoveOptions{})
+	if err != nil {
+		return errors.Wrap(err, "copy to cache volume")
+	}
+
+	existing, err := c.Cli.VolumeInspect(ctx, name)
+	if dockercli.IsErrNotFound(err) {
+		return c.replaceVolume(ctx, name, cacheVolumeLabels(key, appDir), tmpName, true)
+	} else if err != nil {
+		return errors.Wrapf(err, "inspect cache volume %s", name)
+	}
+
+	backupName := cacheBackupPrefix + strings.TrimPrefix(name, cacheVolumePrefix)
+	if err := c.createTmpVolume(ctx, backupName); err != nil {
+		return err
+	}
+	defer c.Cli.VolumeRemove(ctx, backupName, true)
+	if err := c.copyVolume(ctx, name, backupName, false); err != nil {
+		return errors.Wrap(err, "back up cache volume")
+	}
+
+	if err := c.replaceVolume(ctx, name, cacheVolumeLabels(key, appDir), tmpName, true); err != nil {
+		if restoreErr := c.replaceVolume(ctx, name, existing.Labels, backupName, false); restoreErr != nil {
+			return errors.Wrapf(err, "restore cache volume %s from %s: %s", name, backupName, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// createTmpVolume creates a volume used while importing a cache, which is
+// not listed as a cache.
+func (c *Cache) createTmpVolume(ctx context.Context, name string) error {
+	if _, err := c.Cli.VolumeCreate(ctx, volume.VolumeCreateBody{
+		Name:   name,
+		Labels: map[string]string{packVersionLabel: Version},
+	}); err != nil {
+		return errors.Wrapf(err, "create volume %s", name)
+	}
+	return nil
+}
+
+// replaceVolume recreates the volume name with the labels and the contents of
+// the volume from.
+func (c *Cache) replaceVolume(ctx context.Context, name string, labels map[string]string, from string, markUsed bool) error {
+	if err := c.Cli.VolumeRemove(ctx, name, false); err != nil && !dockercli.IsErrNotFound(err) {
+		return errors.Wrapf(err, "remove cache volume %s", name)
+	}
+	if _, err := c.Cli.VolumeCreate(ctx, volume.VolumeCreateBody{
+		Name:   name,
+		Labels: labels,
+	}); err != nil {
+		return errors.Wrapf(err, "create cache volume %s", name)
+	}
+	return c.copyVolume(ctx, from, name, markUsed)
+}
+
+// copyVolume copies the contents of the volume from to the volume to, and
+// marks to as used by a build when markUsed is set.
+func (c *Cache) copyVolume(ctx context.Context, from, to string, markUsed bool) error {
+	ctrID, err := c.helperContainer([]string{"cp", "-a", "/from/.", "/cache/"}, from+":/from:ro", to+":/cache")
+	if err != nil {
+		return err
+	}
+	defer c.Cli.ContainerRemove(ctx, ctrID, dockertypes.ContainerRemoveOptions{})
+	if err := c.Cli.RunContainer(ctx, ctrID, ioutil.Discard, ioutil.Discard); err != nil {
+		return errors.Wrap(err, "copy to cache volume")
+	}
+	if !markUsed {
+		return nil
+	}
+	return markCacheUsed(ctx, c.Cli, ctrID)
+}
+
+// checkCacheArchive reads the whole archive and fails when it is truncated,
+// is not a tar archive or has entries outside of "cache/".
+func checkCacheArchive(r io.Reader) error {
+	tr := tar.NewReader(r)
+	n := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "invalid cache archive")
+		}
+		if name := strings.TrimPrefix(hdr.Name, "./"); name != "cache" && name != "cache/" && !strings.HasPrefix(name, "cache/") {
+			return fmt.Errorf(`invalid cache archive: unexpected entry "%s" outside of cache/`, hdr.Name)
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return errors.Wrap(err, "invalid cache archive")
+		}
+		n++
+	}
+	if n == 0 {
+		return errors.New("invalid cache archive: no entries")
+	}
+	return nil
+}
+
+// helperContainer creates a container of the cache image, running cmd as
+// root with the binds.
+func (c *Cache) helperContainer(cmd []string, binds ...string) (string, error) {
+	ctx := context.Background()
+	if _, _, err := c.Cli.ImageInspectWithRaw(ctx, c.Image); dockercli.IsErrNotFound(err) {
+		c.Log.Printf("Pulling image '%s'", c.Image)
+		if err := c.Cli.PullImage(ctx, c.Image); err != nil {
+			return "", err
+		}
+	}
+	ctr, err := c.Cli.ContainerCreate(ctx, &container.Config{
+		Image:  c.Image,
+		Cmd:    cmd,
+		User:   "root",
+		Labels: map[string]string{packVersionLabel: Version},
+	}, &container.HostConfig{
+		Binds: binds,
+	}, nil, "")
+	if err != nil {
+		return "", errors.Wrap(err, "cache container create")
+	}
+	return ctr.ID, nil
+}
+
+// ensureCacheVolume creates the cache volume, labelled with its key and the app
+// path, if it does not exist yet.
+func ensureCacheVolume(cli Docker, name, key, appDir string) error {
+	_, err := cli.VolumeInspect(context.Background(), name)
+	if err == nil {
+		return nil
+	} else if !dockercli.IsErrNotFound(err) {
+		return errors.Wrapf(err, "inspect cache volume %s", name)
+	}
+	return createCacheVolume(cli, name, key, appDir)
+}
+
+func createCacheVolume(cli Docker, name, key, appDir string) error {
+	if _, err := cli.VolumeCreate(context.Background(), volume.VolumeCreateBody{
+		Name:   name,
+		Labels: cacheVolumeLabels(key, appDir),
+	}); err != nil {
+		return errors.Wrapf(err, "create cache volume %s", name)
+	}
+	return nil
+}
+
+func cacheVolumeLabels(key, appDir string) map[string]string {
+	labels := map[string]string{cacheKeyLabel: key}
+	if appDir != "" {
+		labels[cacheAppPathLabel] = appDir
+	}
+	return labels
+}
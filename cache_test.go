@@ -0,0 +1,334 @@
+package pack_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildpack/pack"
+	"github.com/buildpack/pack/mocks"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/golang/mock/gomock"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestCache(t *testing.T) {
+	spec.Run(t, "cache", testCache, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testCache(t *testing.T, when spec.G, it spec.S) {
+	var (
+		subject        *pack.Cache
+		mockController *gomock.Controller
+		mockDocker     *mocks.MockDocker
+		old, recent    time.Time
+	)
+
+	it.Before(func() {
+		mockController = gomock.NewController(t)
+		mockDocker = mocks.NewMockDocker(mockController)
+		subject = &pack.Cache{
+			Cli:   mockDocker,
+			Log:   log.New(ioutil.Discard, "", log.LstdFlags),
+			Image: "some/build",
+		}
+		old = time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+		recent = time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+	})
+
+	it.After(func() {
+		mockController.Finish()
+	})
+
+	expectVolumes := func() {
+		mockDocker.EXPECT().VolumeList(gomock.Any(), gomock.Any()).Return(volume.VolumeListOKBody{
+			Volumes: []*dockertypes.Volume{
+				{Name: "pack-cache-bbb", CreatedAt: recent.Format(time.RFC3339), Labels: map[string]string{"io.buildpacks.pack.cache.key": "some-key", "io.buildpacks.pack.cache.app-path": "/app/b"}},
+				{Name: "pack-cache-aaa", CreatedAt: old.Format(time.RFC3339), Labels: map[string]string{"io.buildpacks.pack.cache.app-path": "/app/a"}},
+				{Name: "pack-cache-import-aaa", CreatedAt: old.Format(time.RFC3339)},
+				{Name: "pack-cache-backup-aaa", CreatedAt: old.Format(time.RFC3339)},
+				{Name: "other-volume"},
+			},
+		}, nil)
+		mockDocker.EXPECT().DiskUsage(gomock.Any()).Return(dockertypes.DiskUsage{
+			Volumes: []*dockertypes.Volume{
+				{Name: "pack-cache-aaa", UsageData: &dockertypes.VolumeUsageData{Size: 1234}},
+			},
+		}, nil)
+	}
+
+	// expectHelperContainer expects a helper container to be created and
+	// removed, and returns the expectation of its creation.
+	expectHelperContainer := func(id string) *gomock.Call {
+		mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/build").Return(dockertypes.ImageInspect{}, nil, nil)
+		mockDocker.EXPECT().ContainerRemove(gomock.Any(), id, gomock.Any())
+		return mockDocker.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), nil, "").Return(container.ContainerCreateCreatedBody{ID: id}, nil)
+	}
+
+	when("#List", func() {
+		it("returns the cache volumes sorted by key", func() {
+			expectVolumes()
+
+			volumes, err := subject.List()
+			assertNil(t, err)
+			assertEq(t, volumes, []pack.CacheVolume{
+				{Name: "pack-cache-aaa", Key: "/app/a", AppPath: "/app/a", Size: 1234, CreatedAt: old},
+				{Name: "pack-cache-bbb", Key: "some-key", AppPath: "/app/b", Size: -1, CreatedAt: recent},
+			})
+		})
+	})
+
+	when("#Prune", func() {
+		it.Before(func() {
+			expectVolumes()
+			expectHelperContainer("prune-ctr").Do(func(_ context.Context, config *container.Config, hostConfig *container.HostConfig, _ *network.NetworkingConfig, _ string) {
+				assertEq(t, hostConfig.Binds, []string{"pack-cache-aaa:/caches/pack-cache-aaa:ro"})
+			})
+		})
+
+		it("removes the volumes last used before the given age", func() {
+			mockDocker.EXPECT().CopyFromContainer(gomock.Any(), "prune-ctr", "/caches/pack-cache-aaa/.pack-last-used").Return(lastUsedTar(t, old), dockertypes.ContainerPathStat{}, nil)
+			mockDocker.EXPECT().VolumeRemove(gomock.Any(), "pack-cache-aaa", false).Return(nil)
+
+			pruned, err := subject.Prune(24*time.Hour, false)
+			assertNil(t, err)
+			assertEq(t, len(pruned), 1)
+			assertEq(t, pruned[0].Name, "pack-cache-aaa")
+		})
+
+		it("keeps the old volumes used by a recent build", func() {
+			mockDocker.EXPECT().CopyFromContainer(gomock.Any(), "prune-ctr", "/caches/pack-cache-aaa/.pack-last-used").Return(lastUsedTar(t, recent), dockertypes.ContainerPathStat{}, nil)
+
+			pruned, err := subject.Prune(24*time.Hour, false)
+			assertNil(t, err)
+			assertEq(t, len(pruned), 0)
+		})
+
+		it("ages the volumes never used by a build from their creation", func() {
+			mockDocker.EXPECT().CopyFromContainer(gomock.Any(), "prune-ctr", "/caches/pack-cache-aaa/.pack-last-used").Return(nil, dockertypes.ContainerPathStat{}, notFoundError{})
+			mockDocker.EXPECT().VolumeRemove(gomock.Any(), "pack-cache-aaa", false).Return(nil)
+
+			pruned, err := subject.Prune(24*time.Hour, false)
+			assertNil(t, err)
+			assertEq(t, len(pruned), 1)
+		})
+
+		when("dry run", func() {
+			it("does not remove anything", func() {
+				mockDocker.EXPECT().CopyFromContainer(gomock.Any(), "prune-ctr", "/caches/pack-cache-aaa/.pack-last-used").Return(lastUsedTar(t, old), dockertypes.ContainerPathStat{}, nil)
+
+				pruned, err := subject.Prune(24*time.Hour, true)
+				assertNil(t, err)
+				assertEq(t, len(pruned), 1)
+				assertEq(t, pruned[0].AppPath, "/app/a")
+			})
+		})
+	})
+
+	when("#Remove", func() {
+		it("removes the cache volume of the key", func() {
+			mockDocker.EXPECT().VolumeRemove(gomock.Any(), pack.CacheVolumeName("some-key"), false).Return(nil)
+
+			assertNil(t, subject.Remove("some-key"))
+		})
+
+		it("errors when there is no cache for the key", func() {
+			mockDocker.EXPECT().VolumeRemove(gomock.Any(), pack.CacheVolumeName("some-key"), false).Return(notFoundError{})
+
+			assertError(t, subject.Remove("some-key"), `no cache found for "some-key"`)
+		})
+	})
+
+	when("#Export", func() {
+		it("writes the contents of the cache volume", func() {
+			name := pack.CacheVolumeName("some-key")
+			mockDocker.EXPECT().VolumeInspect(gomock.Any(), name).Return(dockertypes.Volume{Name: name}, nil)
+			expectHelperContainer("export-ctr").Do(func(_ context.Context, config *container.Config, hostConfig *container.HostConfig, _ *network.NetworkingConfig, _ string) {
+				assertEq(t, hostConfig.Binds, []string{name + ":/cache:ro"})
+			})
+			mockDocker.EXPECT().CopyFromContainer(gomock.Any(), "export-ctr", "/cache").Return(ioutil.NopCloser(strings.NewReader("some-archive")), dockertypes.ContainerPathStat{}, nil)
+
+			var buf bytes.Buffer
+			assertNil(t, subject.Export("some-key", &buf))
+			assertEq(t, buf.String(), "some-archive")
+		})
+
+		it("errors when there is no cache for the key", func() {
+			mockDocker.EXPECT().VolumeInspect(gomock.Any(), pack.CacheVolumeName("some-key")).Return(dockertypes.Volume{}, notFoundError{})
+
+			assertError(t, subject.Export("some-key", ioutil.Discard), `no cache found for "some-key"`)
+		})
+	})
+
+	when("#Import", func() {
+		var name, tmpName string
+		it.Before(func() {
+			name = pack.CacheVolumeName("some-key")
+			tmpName = "pack-cache-import-" + strings.TrimPrefix(name, "pack-cache-")
+		})
+
+		it("replaces the cache volume once the archive is copied to a temporary volume", func() {
+			var copied bytes.Buffer
+			gomock.InOrder(
+				mockDocker.EXPECT().VolumeCreate(gomock.Any(), volumeNamed(tmpName)),
+				expectHelperContainer("import-ctr"),
+				mockDocker.EXPECT().CopyToContainer(gomock.Any(), "import-ctr", "/", gomock.Any(), gomock.Any()).Do(func(_ context.Context, _, _ string, r io.Reader, _ dockertypes.CopyToContainerOptions) {
+					io.Copy(&copied, r)
+				}),
+				mockDocker.EXPECT().VolumeInspect(gomock.Any(), name).Return(dockertypes.Volume{}, notFoundError{}),
+				mockDocker.EXPECT().VolumeRemove(gomock.Any(), name, false),
+				mockDocker.EXPECT().VolumeCreate(gomock.Any(), volume.VolumeCreateBody{
+					Name:   name,
+					Labels: map[string]string{"io.buildpacks.pack.cache.key": "some-key", "io.buildpacks.pack.cache.app-path": "/some/app"},
+				}),
+				expectHelperContainer("copy-ctr").Do(func(_ context.Context, config *container.Config, hostConfig *container.HostConfig, _ *network.NetworkingConfig, _ string) {
+					assertEq(t, config.Cmd, strslice.StrSlice{"cp", "-a", "/from/.", "/cache/"})
+					assertEq(t, hostConfig.Binds, []string{tmpName + ":/from:ro", name + ":/cache"})
+				}),
+				mockDocker.EXPECT().RunContainer(gomock.Any(), "copy-ctr", gomock.Any(), gomock.Any()),
+				mockDocker.EXPECT().CopyToContainer(gomock.Any(), "copy-ctr", "/", gomock.Any(), gomock.Any()),
+				mockDocker.EXPECT().VolumeRemove(gomock.Any(), tmpName, true),
+			)
+
+			archive := cacheArchive(t, map[string]string{"cache/some-layer/file": "content"})
+			assertNil(t, subject.Import("some-key", "/some/app", bytes.NewReader(archive)))
+			assertEq(t, copied.Bytes(), archive)
+		})
+
+		when("a cache volume exists", func() {
+			var (
+				backupName string
+				oldLabels  map[string]string
+			)
+			it.Before(func() {
+				backupName = "pack-cache-backup-" + strings.TrimPrefix(name, "pack-cache-")
+				oldLabels = map[string]string{"io.buildpacks.pack.cache.key": "some-key", "io.buildpacks.pack.cache.app-path": "/old/app"}
+				mockDocker.EXPECT().VolumeCreate(gomock.Any(), volumeNamed(tmpName))
+				expectHelperContainer("import-ctr")
+				mockDocker.EXPECT().CopyToContainer(gomock.Any(), "import-ctr", "/", gomock.Any(), gomock.Any())
+			})
+
+			it("backs up the cache volume before replacing it", func() {
+				gomock.InOrder(
+					mockDocker.EXPECT().VolumeInspect(gomock.Any(), name).Return(dockertypes.Volume{Name: name, Labels: oldLabels}, nil),
+					mockDocker.EXPECT().VolumeCreate(gomock.Any(), volumeNamed(backupName)),
+					expectHelperContainer("backup-ctr").Do(func(_ context.Context, config *container.Config, hostConfig *container.HostConfig, _ *network.NetworkingConfig, _ string) {
+						assertEq(t, config.Cmd, strslice.StrSlice{"cp", "-a", "/from/.", "/cache/"})
+						assertEq(t, hostConfig.Binds, []string{name + ":/from:ro", backupName + ":/cache"})
+					}),
+					mockDocker.EXPECT().RunContainer(gomock.Any(), "backup-ctr", gomock.Any(), gomock.Any()),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), name, false),
+					mockDocker.EXPECT().VolumeCreate(gomock.Any(), volumeNamed(name)),
+					expectHelperContainer("copy-ctr"),
+					mockDocker.EXPECT().RunContainer(gomock.Any(), "copy-ctr", gomock.Any(), gomock.Any()),
+					mockDocker.EXPECT().CopyToContainer(gomock.Any(), "copy-ctr", "/", gomock.Any(), gomock.Any()),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), backupName, true),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), tmpName, true),
+				)
+
+				assertNil(t, subject.Import("some-key", "/some/app", bytes.NewReader(cacheArchive(t, map[string]string{"cache/file": "content"}))))
+			})
+
+			it("restores the cache volume when the copy fails", func() {
+				gomock.InOrder(
+					mockDocker.EXPECT().VolumeInspect(gomock.Any(), name).Return(dockertypes.Volume{Name: name, Labels: oldLabels}, nil),
+					mockDocker.EXPECT().VolumeCreate(gomock.Any(), volumeNamed(backupName)),
+					expectHelperContainer("backup-ctr"),
+					mockDocker.EXPECT().RunContainer(gomock.Any(), "backup-ctr", gomock.Any(), gomock.Any()),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), name, false),
+					mockDocker.EXPECT().VolumeCreate(gomock.Any(), volumeNamed(name)),
+					expectHelperContainer("copy-ctr"),
+					mockDocker.EXPECT().RunContainer(gomock.Any(), "copy-ctr", gomock.Any(), gomock.Any()).Return(errors.New("some-error")),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), name, false),
+					mockDocker.EXPECT().VolumeCreate(gomock.Any(), volume.VolumeCreateBody{Name: name, Labels: oldLabels}),
+					expectHelperContainer("restore-ctr").Do(func(_ context.Context, config *container.Config, hostConfig *container.HostConfig, _ *network.NetworkingConfig, _ string) {
+						assertEq(t, hostConfig.Binds, []string{backupName + ":/from:ro", name + ":/cache"})
+					}),
+					mockDocker.EXPECT().RunContainer(gomock.Any(), "restore-ctr", gomock.Any(), gomock.Any()),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), backupName, true),
+					mockDocker.EXPECT().VolumeRemove(gomock.Any(), tmpName, true),
+				)
+
+				err := subject.Import("some-key", "/some/app", bytes.NewReader(cacheArchive(t, map[string]string{"cache/file": "content"})))
+				assertError(t, err, "copy to cache volume: some-error")
+			})
+
+			it("keeps the cache volume when the backup fails", func() {
+				mockDocker.EXPECT().VolumeInspect(gomock.Any(), name).Return(dockertypes.Volume{Name: name, Labels: oldLabels}, nil)
+				mockDocker.EXPECT().VolumeCreate(gomock.Any(), volumeNamed(backupName))
+				expectHelperContainer("backup-ctr")
+				mockDocker.EXPECT().RunContainer(gomock.Any(), "backup-ctr", gomock.Any(), gomock.Any()).Return(errors.New("some-error"))
+				mockDocker.EXPECT().VolumeRemove(gomock.Any(), backupName, true)
+				mockDocker.EXPECT().VolumeRemove(gomock.Any(), tmpName, true)
+
+				err := subject.Import("some-key", "", bytes.NewReader(cacheArchive(t, map[string]string{"cache/file": "content"})))
+				assertError(t, err, "back up cache volume: copy to cache volume: some-error")
+			})
+		})
+
+		it("keeps the cache when the archive is invalid", func() {
+			archive := cacheArchive(t, map[string]string{"cache/some-layer/file": "content"})
+
+			err := subject.Import("some-key", "", bytes.NewReader(archive[:515]))
+			assertNotNil(t, err)
+			assertContains(t, err.Error(), "invalid cache archive")
+		})
+
+		it("keeps the cache when the archive has entries outside of cache/", func() {
+			err := subject.Import("some-key", "", bytes.NewReader(cacheArchive(t, map[string]string{"etc/passwd": "content"})))
+			assertError(t, err, `invalid cache archive: unexpected entry "etc/passwd" outside of cache/`)
+		})
+
+		it("keeps the cache when the copy to the temporary volume fails", func() {
+			mockDocker.EXPECT().VolumeCreate(gomock.Any(), volumeNamed(tmpName))
+			expectHelperContainer("import-ctr")
+			mockDocker.EXPECT().CopyToContainer(gomock.Any(), "import-ctr", "/", gomock.Any(), gomock.Any()).Return(errors.New("some-error"))
+			mockDocker.EXPECT().VolumeRemove(gomock.Any(), tmpName, true)
+
+			err := subject.Import("some-key", "", bytes.NewReader(cacheArchive(t, map[string]string{"cache/file": "content"})))
+			assertError(t, err, "copy to cache volume: some-error")
+		})
+	})
+}
+
+func lastUsedTar(t *testing.T, lastUsed time.Time) io.ReadCloser {
+	t.Helper()
+	return ioutil.NopCloser(bytes.NewReader(cacheArchive(t, map[string]string{".pack-last-used": lastUsed.UTC().Format(time.RFC3339)})))
+}
+
+func cacheArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		assertNil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		assertNil(t, err)
+	}
+	assertNil(t, tw.Close())
+	return buf.Bytes()
+}
+
+// volumeNamed matches the options of a volume creation by volume name.
+type volumeNamed string
+
+func (v volumeNamed) Matches(x interface{}) bool {
+	options, ok := x.(volume.VolumeCreateBody)
+	return ok && options.Name == string(v)
+}
+
+func (v volumeNamed) String() string {
+	return "creates volume " + string(v)
+}
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/buildpack/pack/config"
 	"github.com/buildpack/pack/docker"
@@ -12,18 +23,37 @@ import (
 
 	"github.com/buildpack/pack/fs"
 
+	"github.com/BurntSushi/toml"
 	"github.com/buildpack/pack"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	registryAuthFile   string
+	insecureRegistries []string
 )
 
 func main() {
 	rootCmd := &cobra.Command{Use: "pack"}
+	rootCmd.PersistentFlags().StringVar(&registryAuthFile, "registry-auth-file", "", "path to a docker config file with the registry credentials used to pull, read and publish images (defaults to ~/.docker/config.json)")
+	rootCmd.PersistentFlags().StringArrayVar(&insecureRegistries, "insecure-registry", []string{}, "registry, as host[:port], to access over HTTP or without verifying its TLS certificate, in addition to insecure-registries in the pack config (may be repeated)")
 	for _, f := range [](func() *cobra.Command){
 		buildCommand,
 		createBuilderCommand,
+		rebaseCommand,
+		inspectImageCommand,
+		inspectBuilderCommand,
 		addStackCommand,
 		updateStackCommand,
 		deleteStackCommand,
+		setDefaultStackCommand,
+		stacksCommand,
+		inspectStackCommand,
+		setDefaultBuilderCommand,
+		setDefaultPullPolicyCommand,
+		cacheCommand,
+		gcCommand,
 	} {
 		rootCmd.AddCommand(f())
 	}
@@ -36,27 +66,69 @@ func buildCommand() *cobra.Command {
 	wd, _ := os.Getwd()
 
 	var buildFlags pack.BuildFlags
+	var output, reportPath string
 	buildCommand := &cobra.Command{
-		Use:  "build <image-name>",
-		Args: cobra.MinimumNArgs(1),
+		Use:  "build [<image-name>]",
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			buildFlags.RepoName = args[0]
-			bf, err := pack.DefaultBuildFactory()
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			if len(args) > 0 {
+				buildFlags.RepoName = args[0]
+			}
+			buildFlags.PublishSet = cmd.Flags().Changed("publish")
+			bf, err := pack.DefaultBuildFactory(registryAuthFile, insecureRegistries)
 			if err != nil {
 				return err
 			}
-			b, err := bf.BuildConfigFromFlags(&buildFlags)
+			if output == "json" {
+				// stdout only receives the events, one JSON object per line
+				bf.Stdout = os.Stderr
+				bf.Log = log.New(os.Stderr, "", log.LstdFlags)
+				if cli, ok := bf.Cli.(*docker.Client); ok {
+					cli.PullOutput = os.Stderr
+				}
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			b, err := bf.BuildConfigFromFlags(ctx, &buildFlags)
 			if err != nil {
 				return err
 			}
-			return b.Run()
+			if output == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetEscapeHTML(false)
+				b.OnEvent = func(event pack.BuildEvent) { enc.Encode(event) }
+			}
+			result, err := b.Run(ctx)
+			if reportPath != "" {
+				if reportErr := writeJSONFile(reportPath, result); reportErr != nil && err == nil {
+					return reportErr
+				}
+			}
+			return err
 		},
 	}
 	buildCommand.Flags().StringVarP(&buildFlags.AppDir, "path", "p", wd, "path to app dir")
-	buildCommand.Flags().StringVar(&buildFlags.Builder, "builder", "packs/samples", "builder")
+	buildCommand.Flags().StringVar(&buildFlags.Builder, "builder", "", "builder (defaults to the default builder from the pack config)")
 	buildCommand.Flags().StringVar(&buildFlags.RunImage, "run-image", "", "run image")
 	buildCommand.Flags().BoolVar(&buildFlags.Publish, "publish", false, "publish to registry")
-	buildCommand.Flags().BoolVar(&buildFlags.NoPull, "no-pull", false, "don't pull images before use")
+	buildCommand.Flags().StringVar(&buildFlags.PullPolicy, "pull-policy", "", "when to pull the builder and run images: always, if-not-present or never (defaults to default-pull-policy in the pack config, or always)")
+	addNoPullFlag(buildCommand, &buildFlags.PullPolicy)
+	buildCommand.Flags().StringArrayVarP(&buildFlags.Env, "env", "e", []string{}, "build-time environment variable in the form KEY=VALUE, or KEY to take the value from the current environment (may be repeated)")
+	buildCommand.Flags().StringVar(&buildFlags.EnvFile, "env-file", "", "file with build-time environment variables, one KEY=VALUE per line")
+	buildCommand.Flags().StringArrayVar(&buildFlags.Exclude, "exclude", []string{}, "gitignore-style pattern of app files to leave out of the build, added to those in .packignore (may be repeated)")
+	buildCommand.Flags().StringVar(&buildFlags.OutputOCI, "output-oci", "", "write the image to an OCI image layout directory instead of the daemon")
+	buildCommand.Flags().StringVar(&buildFlags.OutputTar, "output-tar", "", "write the image to a tarball that can be loaded with docker load instead of the daemon")
+	buildCommand.Flags().BoolVar(&buildFlags.ClearCache, "clear-cache", false, "clear the build cache before building")
+	buildCommand.Flags().StringVar(&buildFlags.CacheKey, "cache-key", "", "name of the build cache to use (defaults to the absolute app path)")
+	buildCommand.Flags().BoolVar(&buildFlags.CacheKeyFromImage, "cache-key-from-image", false, "share the build cache between builds of the same image repository")
+	buildCommand.Flags().StringVar(&buildFlags.Descriptor, "descriptor", "", "path to the project descriptor (defaults to project.toml in the app dir)")
+	buildCommand.Flags().StringVarP(&output, "output", "o", "table", `output format: "table" for the build logs or "json" to print the build events as JSON lines, and the logs to stderr`)
+	buildCommand.Flags().StringVar(&buildFlags.DigestFile, "digest-file", "", "write the digest of the image to a file when it is published or written with --output-oci, and its image ID otherwise (alias: --iidfile)")
+	addFlagAlias(buildCommand, "iidfile", "digest-file")
+	buildCommand.Flags().StringVar(&reportPath, "report", "", "write a JSON report of the build to a file, even when the build fails")
 	return buildCommand
 }
 
@@ -68,7 +140,7 @@ func createBuilderCommand() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			flags.RepoName = args[0]
 
-			docker, err := docker.New()
+			docker, err := docker.New(registryAuthFile)
 			if err != nil {
 				return err
 			}
@@ -81,7 +153,7 @@ func createBuilderCommand() *cobra.Command {
 				Log:    log.New(os.Stdout, "", log.LstdFlags),
 				Docker: docker,
 				Config: cfg,
-				Images: &image.Client{},
+				Images: imageClient(cfg),
 			}
 			builderConfig, err := builderFactory.BuilderConfigFromFlags(flags)
 			if err != nil {
@@ -90,17 +162,61 @@ func createBuilderCommand() *cobra.Command {
 			return builderFactory.Create(builderConfig)
 		},
 	}
-	createBuilderCommand.Flags().BoolVar(&flags.NoPull, "no-pull", false, "don't pull stack image before use")
+	createBuilderCommand.Flags().StringVar(&flags.PullPolicy, "pull-policy", "", "when to pull the stack build image: always, if-not-present or never (defaults to default-pull-policy in the pack config, or always)")
+	addNoPullFlag(createBuilderCommand, &flags.PullPolicy)
 	createBuilderCommand.Flags().StringVarP(&flags.BuilderTomlPath, "builder-config", "b", "", "path to builder.toml file")
 	createBuilderCommand.Flags().StringVarP(&flags.StackID, "stack", "s", "", "stack ID")
 	createBuilderCommand.Flags().BoolVar(&flags.Publish, "publish", false, "publish to registry")
+	createBuilderCommand.Flags().StringVar(&flags.OutputOCI, "output-oci", "", "write the builder to an OCI image layout directory instead of the daemon")
+	createBuilderCommand.Flags().StringVar(&flags.OutputTar, "output-tar", "", "write the builder to a tarball that can be loaded with docker load instead of the daemon")
+	createBuilderCommand.Flags().StringVar(&flags.DigestFile, "digest-file", "", "write the digest of the builder to a file when it is published or written with --output-oci, and its image ID otherwise (alias: --iidfile)")
+	addFlagAlias(createBuilderCommand, "iidfile", "digest-file")
 	return createBuilderCommand
 }
 
+func rebaseCommand() *cobra.Command {
+	flags := pack.RebaseFlags{}
+	rebaseCommand := &cobra.Command{
+		Use:   "rebase <image-name>",
+		Short: "Replace the run image of an app image without rebuilding it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.RepoName = args[0]
+
+			docker, err := docker.New(registryAuthFile)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+			if err != nil {
+				return err
+			}
+			factory := pack.RebaseFactory{
+				Log:    log.New(os.Stdout, "", log.LstdFlags),
+				Docker: docker,
+				Images: imageClient(cfg),
+				Config: cfg,
+			}
+			rebaseConfig, err := factory.RebaseConfigFromFlags(flags)
+			if err != nil {
+				return err
+			}
+			return factory.Rebase(rebaseConfig)
+		},
+	}
+	rebaseCommand.Flags().StringVar(&flags.RunImage, "run-image", "", "run image to rebase on (defaults to the run image recorded in the image)")
+	rebaseCommand.Flags().BoolVar(&flags.Publish, "publish", false, "rebase the image on the registry")
+	rebaseCommand.Flags().StringVar(&flags.PullPolicy, "pull-policy", "", "when to pull the run image: always, if-not-present or never (defaults to default-pull-policy in the pack config, or always)")
+	addNoPullFlag(rebaseCommand, &flags.PullPolicy)
+	return rebaseCommand
+}
+
 func addStackCommand() *cobra.Command {
 	flags := struct {
-		BuildImages []string
-		RunImages   []string
+		BuildImages    []string
+		RunImages      []string
+		DefaultBuilder string
+		SkipValidation bool
 	}{}
 	addStackCommand := &cobra.Command{
 		Use:  "add-stack <stack-name> --run-image=<name> --build-image=<name>",
@@ -110,11 +226,18 @@ func addStackCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			if err := cfg.Add(config.Stack{
-				ID:          args[0],
-				BuildImages: flags.BuildImages,
-				RunImages:   flags.RunImages,
-			}); err != nil {
+			stack := config.Stack{
+				ID:             args[0],
+				BuildImages:    flags.BuildImages,
+				RunImages:      flags.RunImages,
+				DefaultBuilder: flags.DefaultBuilder,
+			}
+			if !flags.SkipValidation {
+				if err := validateStack(cfg, stack); err != nil {
+					return err
+				}
+			}
+			if err := cfg.Add(stack); err != nil {
 				return err
 			}
 			fmt.Printf("%s successfully added\n", args[0])
@@ -123,25 +246,58 @@ func addStackCommand() *cobra.Command {
 	}
 	addStackCommand.Flags().StringSliceVarP(&flags.BuildImages, "build-image", "b", []string{}, "build image to be used for bulder images built with the stack")
 	addStackCommand.Flags().StringSliceVarP(&flags.RunImages, "run-image", "r", []string{}, "run image to be used for runnable images built with the stack")
+	addStackCommand.Flags().StringVar(&flags.DefaultBuilder, "default-builder", "", "builder to use for builds when this is the default stack")
+	addStackCommand.Flags().BoolVar(&flags.SkipValidation, "skip-validation", false, "don't check that the images exist and carry the stack ID label")
 	return addStackCommand
 }
 
 func updateStackCommand() *cobra.Command {
 	flags := struct {
-		BuildImages []string
-		RunImages   []string
+		BuildImages         []string
+		RunImages           []string
+		AddBuildImages      []string
+		RemoveBuildImages   []string
+		AddRunImages        []string
+		RemoveRunImages     []string
+		DefaultBuilder      string
+		UnsetDefaultBuilder bool
+		SkipValidation      bool
 	}{}
 	updateStackCommand := &cobra.Command{
 		Use:  "update-stack <stack-name> --run-image=<name> --build-image=<name>",
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(flags.BuildImages) > 0 && len(flags.AddBuildImages)+len(flags.RemoveBuildImages) > 0 {
+				return fmt.Errorf("--build-image cannot be combined with --add-build-image or --remove-build-image")
+			}
+			if len(flags.RunImages) > 0 && len(flags.AddRunImages)+len(flags.RemoveRunImages) > 0 {
+				return fmt.Errorf("--run-image cannot be combined with --add-run-image or --remove-run-image")
+			}
+			if flags.DefaultBuilder != "" && flags.UnsetDefaultBuilder {
+				return fmt.Errorf("--default-builder cannot be combined with --unset-default-builder")
+			}
 			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
 			if err != nil {
 				return err
 			}
-			if err := cfg.Update(args[0], config.Stack{
-				BuildImages: flags.BuildImages,
-				RunImages:   flags.RunImages,
+			if !flags.SkipValidation {
+				if err := validateStack(cfg, config.Stack{
+					ID:          args[0],
+					BuildImages: append(flags.BuildImages, flags.AddBuildImages...),
+					RunImages:   append(flags.RunImages, flags.AddRunImages...),
+				}); err != nil {
+					return err
+				}
+			}
+			if err := cfg.UpdateStack(args[0], config.StackUpdate{
+				BuildImages:         flags.BuildImages,
+				RunImages:           flags.RunImages,
+				AddBuildImages:      flags.AddBuildImages,
+				RemoveBuildImages:   flags.RemoveBuildImages,
+				AddRunImages:        flags.AddRunImages,
+				RemoveRunImages:     flags.RemoveRunImages,
+				DefaultBuilder:      flags.DefaultBuilder,
+				UnsetDefaultBuilder: flags.UnsetDefaultBuilder,
 			}); err != nil {
 				return err
 			}
@@ -151,9 +307,31 @@ func updateStackCommand() *cobra.Command {
 	}
 	updateStackCommand.Flags().StringSliceVarP(&flags.BuildImages, "build-image", "b", []string{}, "build image to be used for bulder images built with the stack")
 	updateStackCommand.Flags().StringSliceVarP(&flags.RunImages, "run-image", "r", []string{}, "run image to be used for runnable images built with the stack")
+	updateStackCommand.Flags().StringSliceVar(&flags.AddBuildImages, "add-build-image", []string{}, "build image to add to the existing build images of the stack")
+	updateStackCommand.Flags().StringSliceVar(&flags.RemoveBuildImages, "remove-build-image", []string{}, "build image to remove from the stack")
+	updateStackCommand.Flags().StringSliceVar(&flags.AddRunImages, "add-run-image", []string{}, "run image to add to the existing run images of the stack")
+	updateStackCommand.Flags().StringSliceVar(&flags.RemoveRunImages, "remove-run-image", []string{}, "run image to remove from the stack")
+	updateStackCommand.Flags().StringVar(&flags.DefaultBuilder, "default-builder", "", "builder to use for builds when this is the default stack")
+	updateStackCommand.Flags().BoolVar(&flags.UnsetDefaultBuilder, "unset-default-builder", false, "remove the default builder of the stack")
+	updateStackCommand.Flags().BoolVar(&flags.SkipValidation, "skip-validation", false, "don't check that the images exist and carry the stack ID label")
 	return updateStackCommand
 }
 
+func validateStack(cfg *config.Config, stack config.Stack) error {
+	docker, err := docker.New(registryAuthFile)
+	if err != nil {
+		return err
+	}
+	validator := pack.StackValidator{
+		Cli:    docker,
+		Images: imageClient(cfg),
+	}
+	if err := validator.Validate(stack); err != nil {
+		return fmt.Errorf("%s (use --skip-validation to skip this check)", err)
+	}
+	return nil
+}
+
 func deleteStackCommand() *cobra.Command {
 	addStackCommand := &cobra.Command{
 		Use:  "delete-stack <stack-name>",
@@ -172,3 +350,708 @@ func deleteStackCommand() *cobra.Command {
 	}
 	return addStackCommand
 }
+
+func setDefaultStackCommand() *cobra.Command {
+	setDefaultStackCommand := &cobra.Command{
+		Use:  "set-default-stack <stack-name>",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+			if err != nil {
+				return err
+			}
+			if err := cfg.SetDefaultStack(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("%s is now the default stack\n", args[0])
+			return nil
+		},
+	}
+	return setDefaultStackCommand
+}
+
+func setDefaultBuilderCommand() *cobra.Command {
+	var unset bool
+	setDefaultBuilderCommand := &cobra.Command{
+		Use:   "set-default-builder <builder-image-name | --unset>",
+		Short: "Set the builder used when neither the build nor the default stack names one",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if unset == (len(args) == 1) {
+				return fmt.Errorf("either a builder image name or --unset must be given")
+			}
+			if len(args) == 1 && args[0] == "" {
+				return fmt.Errorf("builder image name cannot be empty, use --unset to unset the default builder")
+			}
+			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+			if err != nil {
+				return err
+			}
+			if unset {
+				if err := cfg.SetDefaultBuilder(""); err != nil {
+					return err
+				}
+				fmt.Println("default builder unset")
+				return nil
+			}
+			if err := cfg.SetDefaultBuilder(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("%s is now the default builder\n", args[0])
+			return nil
+		},
+	}
+	setDefaultBuilderCommand.Flags().BoolVar(&unset, "unset", false, "unset the default builder")
+	return setDefaultBuilderCommand
+}
+
+func setDefaultPullPolicyCommand() *cobra.Command {
+	setDefaultPullPolicyCommand := &cobra.Command{
+		Use:   "set-default-pull-policy <always|if-not-present|never>",
+		Short: "Set when images are pulled when --pull-policy is not given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy, err := pack.ParsePullPolicy(args[0])
+			if err != nil {
+				return err
+			}
+			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+			if err != nil {
+				return err
+			}
+			if err := cfg.SetDefaultPullPolicy(string(policy)); err != nil {
+				return err
+			}
+			fmt.Printf("%s is now the default pull policy\n", policy)
+			return nil
+		},
+	}
+	return setDefaultPullPolicyCommand
+}
+
+// addNoPullFlag adds the deprecated --no-pull flag, which sets the pull
+// policy to never.
+func addNoPullFlag(cmd *cobra.Command, pullPolicy *string) {
+	cmd.Flags().Var(noPullValue{pullPolicy}, "no-pull", "don't pull images before use")
+	cmd.Flags().Lookup("no-pull").NoOptDefVal = "true"
+	cmd.Flags().MarkDeprecated("no-pull", "use --pull-policy never")
+}
+
+// addFlagAlias makes --alias another name of the flag --name of cmd.
+func addFlagAlias(cmd *cobra.Command, alias, name string) {
+	normalize := cmd.Flags().GetNormalizeFunc()
+	cmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, flagName string) pflag.NormalizedName {
+		if flagName == alias {
+			flagName = name
+		}
+		return normalize(f, flagName)
+	})
+}
+
+type noPullValue struct {
+	pullPolicy *string
+}
+
+func (v noPullValue) String() string { return "false" }
+func (v noPullValue) Type() string   { return "bool" }
+
+func (v noPullValue) Set(s string) error {
+	noPull, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if noPull {
+		*v.pullPolicy = string(pack.PullNever)
+	}
+	return nil
+}
+
+func stacksCommand() *cobra.Command {
+	var output string
+	stacksCommand := &cobra.Command{
+		Use:  "stacks",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				type stackJSON struct {
+					ID          string   `json:"id"`
+					Default     bool     `json:"default"`
+					BuildImages []string `json:"build-images"`
+					RunImages   []string `json:"run-images"`
+				}
+				stacks := []stackJSON{}
+				for _, stack := range cfg.Stacks {
+					stacks = append(stacks, stackJSON{
+						ID:          stack.ID,
+						Default:     stack.ID == cfg.DefaultStackID,
+						BuildImages: nonNil(stack.BuildImages),
+						RunImages:   nonNil(stack.RunImages),
+					})
+				}
+				return printJSON(stacks)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "STACK ID\tDEFAULT\tBUILD IMAGES\tRUN IMAGES")
+			for _, stack := range cfg.Stacks {
+				isDefault := ""
+				if stack.ID == cfg.DefaultStackID {
+					isDefault = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", stack.ID, isDefault, strings.Join(stack.BuildImages, ", "), strings.Join(stack.RunImages, ", "))
+			}
+			return w.Flush()
+		},
+	}
+	stacksCommand.Flags().StringVarP(&output, "output", "o", "table", `output format: "table" or "json"`)
+	return stacksCommand
+}
+
+func inspectStackCommand() *cobra.Command {
+	var output string
+	inspectStackCommand := &cobra.Command{
+		Use:  "inspect-stack <stack-name>",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+			if err != nil {
+				return err
+			}
+			stack, err := cfg.Get(args[0])
+			if err != nil {
+				return err
+			}
+
+			type registryJSON struct {
+				Registry   string `json:"registry"`
+				BuildImage string `json:"build-image"`
+				RunImage   string `json:"run-image"`
+			}
+			// An unknown registry matches nothing, so ImageByRegistry falls back to the first image
+			fallback := registryJSON{Registry: "<other>"}
+			fallback.BuildImage, _ = config.ImageByRegistry("", stack.BuildImages)
+			fallback.RunImage, _ = config.ImageByRegistry("", stack.RunImages)
+			registries := []registryJSON{}
+			for _, reg := range stack.Registries() {
+				r := registryJSON{Registry: reg}
+				r.BuildImage, _ = config.ImageByRegistry(reg, stack.BuildImages)
+				r.RunImage, _ = config.ImageByRegistry(reg, stack.RunImages)
+				registries = append(registries, r)
+			}
+
+			if output == "json" {
+				return printJSON(struct {
+					ID          string         `json:"id"`
+					Default     bool           `json:"default"`
+					BuildImages []string       `json:"build-images"`
+					RunImages   []string       `json:"run-images"`
+					Registries  []registryJSON `json:"registries"`
+					Fallback    registryJSON   `json:"fallback"`
+				}{
+					ID:          stack.ID,
+					Default:     stack.ID == cfg.DefaultStackID,
+					BuildImages: nonNil(stack.BuildImages),
+					RunImages:   nonNil(stack.RunImages),
+					Registries:  registries,
+					Fallback:    fallback,
+				})
+			}
+
+			if stack.ID == cfg.DefaultStackID {
+				fmt.Printf("Stack: %s (default)\n\n", stack.ID)
+			} else {
+				fmt.Printf("Stack: %s\n\n", stack.ID)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "REGISTRY\tBUILD IMAGE\tRUN IMAGE")
+			for _, r := range append(registries, fallback) {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.Registry, r.BuildImage, r.RunImage)
+			}
+			return w.Flush()
+		},
+	}
+	inspectStackCommand.Flags().StringVarP(&output, "output", "o", "table", `output format: "table" or "json"`)
+	return inspectStackCommand
+}
+
+func inspectImageCommand() *cobra.Command {
+	var flags struct {
+		Output string
+		Remote bool
+	}
+	inspectImageCommand := &cobra.Command{
+		Use:   "inspect-image <image-name>",
+		Short: "Show the build metadata of an app image",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(flags.Output); err != nil {
+				return err
+			}
+			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+			if err != nil {
+				return err
+			}
+			inspector := pack.ImageInspector{
+				Config: cfg,
+				Images: imageClient(cfg),
+			}
+			info, err := inspector.Inspect(args[0], !flags.Remote)
+			if err != nil {
+				return err
+			}
+			if flags.Output == "json" {
+				return printJSON(info)
+			}
+
+			m := info.Metadata
+			fmt.Printf("Image: %s\n", info.RepoName)
+			fmt.Printf("Stack: %s\n", info.StackID)
+			fmt.Printf("Run Image:\n  Name: %s\n  SHA: %s\n", m.RunImage.Name, m.RunImage.SHA)
+			fmt.Printf("App Layer: %s\n", m.App.SHA)
+			fmt.Printf("Config Layer: %s\n", m.Config.SHA)
+			fmt.Println("Buildpacks:")
+			for _, bp := range m.Buildpacks {
+				fmt.Printf("  %s\n", bp.Key)
+				var layers []string
+				for name := range bp.Layers {
+					layers = append(layers, name)
+				}
+				sort.Strings(layers)
+				for _, name := range layers {
+					layer := bp.Layers[name]
+					fmt.Printf("    %s: %s\n", name, layer.SHA)
+					if layer.Data == nil {
+						continue
+					}
+					var buf bytes.Buffer
+					if err := toml.NewEncoder(&buf).Encode(layer.Data); err != nil {
+						fmt.Printf("      %v\n", layer.Data)
+						continue
+					}
+					for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+						fmt.Printf("      %s\n", line)
+					}
+				}
+			}
+			if info.RunImageOutdated {
+				fmt.Printf("\nWARNING: run image '%s' has changed since the image was built, use 'pack rebase %s' to update it\n", info.CurrentRunImage, info.RepoName)
+			}
+			return nil
+		},
+	}
+	inspectImageCommand.Flags().StringVarP(&flags.Output, "output", "o", "table", `output format: "table" or "json"`)
+	inspectImageCommand.Flags().BoolVar(&flags.Remote, "remote", false, "inspect the image on the registry instead of the daemon")
+	return inspectImageCommand
+}
+
+func inspectBuilderCommand() *cobra.Command {
+	var flags struct {
+		Output string
+		Remote bool
+	}
+	inspectBuilderCommand := &cobra.Command{
+		Use:   "inspect-builder <image-name>",
+		Short: "Show the stack, buildpacks and detection order of a builder image",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(flags.Output); err != nil {
+				return err
+			}
+			cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+			if err != nil {
+				return err
+			}
+			inspector := pack.BuilderInspector{Images: imageClient(cfg)}
+			info, err := inspector.Inspect(args[0], !flags.Remote)
+			if err != nil {
+				return err
+			}
+			if flags.Output == "json" {
+				return printJSON(info)
+			}
+
+			fmt.Printf("Builder: %s\n", info.RepoName)
+			fmt.Printf("Stack: %s\n\n", info.StackID)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "BUILDPACK\tVERSION")
+			for _, bp := range info.Buildpacks {
+				fmt.Fprintf(w, "%s\t%s\n", bp.ID, bp.Version)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			fmt.Println("\nDetection order:")
+			for i, group := range info.Groups {
+				fmt.Printf("  Group %d:\n", i+1)
+				for _, bp := range group {
+					if bp.Version != "" {
+						fmt.Printf("    %s@%s\n", bp.ID, bp.Version)
+					} else {
+						fmt.Printf("    %s\n", bp.ID)
+					}
+				}
+			}
+			return nil
+		},
+	}
+	inspectBuilderCommand.Flags().StringVarP(&flags.Output, "output", "o", "table", `output format: "table" or "json"`)
+	inspectBuilderCommand.Flags().BoolVar(&flags.Remote, "remote", false, "inspect the builder image on the registry instead of the daemon")
+	return inspectBuilderCommand
+}
+
+func validateOutput(output string) error {
+	if output != "table" && output != "json" {
+		return fmt.Errorf(`unknown output format "%s": must be "table" or "json"`, output)
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func cacheCommand() *cobra.Command {
+	cacheCommand := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the build caches of apps",
+	}
+	for _, f := range [](func() *cobra.Command){
+		cacheListCommand,
+		cacheRemoveCommand,
+		cachePruneCommand,
+		cacheExportCommand,
+		cacheImportCommand,
+	} {
+		cacheCommand.AddCommand(f())
+	}
+	return cacheCommand
+}
+
+func cacheListCommand() *cobra.Command {
+	var output string
+	cacheListCommand := &cobra.Command{
+		Use:  "ls",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			cache, err := newCache()
+			if err != nil {
+				return err
+			}
+			volumes, err := cache.List()
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				if volumes == nil {
+					volumes = []pack.CacheVolume{}
+				}
+				return printJSON(volumes)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "VOLUME\tKEY\tAPP PATH\tSIZE\tCREATED")
+			for _, v := range volumes {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Name, v.Key, v.AppPath, humanSize(v.Size), v.CreatedAt.Local().Format("2006-01-02 15:04"))
+			}
+			return w.Flush()
+		},
+	}
+	cacheListCommand.Flags().StringVarP(&output, "output", "o", "table", `output format: "table" or "json"`)
+	return cacheListCommand
+}
+
+func cacheRemoveCommand() *cobra.Command {
+	var cacheKey string
+	cacheRemoveCommand := &cobra.Command{
+		Use:  "rm [<app-path> | --cache-key <key>]",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, _, _, err := cacheKeyArgs(cacheKey, args, 1)
+			if err != nil {
+				return err
+			}
+			cache, err := newCache()
+			if err != nil {
+				return err
+			}
+			if err := cache.Remove(key); err != nil {
+				return err
+			}
+			fmt.Printf("cache for %s has been successfully removed\n", key)
+			return nil
+		},
+	}
+	cacheRemoveCommand.Flags().StringVar(&cacheKey, "cache-key", "", "key of the cache, instead of the app path")
+	return cacheRemoveCommand
+}
+
+func cachePruneCommand() *cobra.Command {
+	var flags struct {
+		OlderThan string
+		DryRun    bool
+	}
+	cachePruneCommand := &cobra.Command{
+		Use:  "prune --older-than <age>",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			olderThan, err := parseAge(flags.OlderThan)
+			if err != nil {
+				return err
+			}
+			cache, err := newCache()
+			if err != nil {
+				return err
+			}
+			pruned, err := cache.Prune(olderThan, flags.DryRun)
+			for _, v := range pruned {
+				if flags.DryRun {
+					fmt.Printf("would remove %s (%s)\n", v.Name, v.Key)
+				} else {
+					fmt.Printf("removed %s (%s)\n", v.Name, v.Key)
+				}
+			}
+			return err
+		},
+	}
+	cachePruneCommand.Flags().StringVar(&flags.OlderThan, "older-than", "", `remove caches last used by a build longer ago than this age, e.g. "72h" or "30d"`)
+	cachePruneCommand.Flags().BoolVar(&flags.DryRun, "dry-run", false, "only list the caches that would be removed")
+	cachePruneCommand.MarkFlagRequired("older-than")
+	return cachePruneCommand
+}
+
+func cacheExportCommand() *cobra.Command {
+	var cacheKey string
+	cacheExportCommand := &cobra.Command{
+		Use:  "export [<app-path> | --cache-key <key>] <tar-file>",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, _, args, err := cacheKeyArgs(cacheKey, args, 2)
+			if err != nil {
+				return err
+			}
+			cache, err := newCache()
+			if err != nil {
+				return err
+			}
+			f, err := os.Create(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := cache.Export(key, f); err != nil {
+				os.Remove(args[0])
+				return err
+			}
+			fmt.Printf("cache for %s has been successfully exported to %s\n", key, args[0])
+			return f.Close()
+		},
+	}
+	cacheExportCommand.Flags().StringVar(&cacheKey, "cache-key", "", "key of the cache, instead of the app path")
+	return cacheExportCommand
+}
+
+func cacheImportCommand() *cobra.Command {
+	var cacheKey string
+	cacheImportCommand := &cobra.Command{
+		Use:  "import [<app-path> | --cache-key <key>] <tar-file>",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, appDir, args, err := cacheKeyArgs(cacheKey, args, 2)
+			if err != nil {
+				return err
+			}
+			cache, err := newCache()
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := cache.Import(key, appDir, f); err != nil {
+				return err
+			}
+			fmt.Printf("cache for %s has been successfully imported from %s\n", key, args[0])
+			return nil
+		},
+	}
+	cacheImportCommand.Flags().StringVar(&cacheKey, "cache-key", "", "key of the cache, instead of the app path")
+	return cacheImportCommand
+}
+
+// cacheKeyArgs resolves the cache key of a cache command taking n arguments,
+// the first of which is the app path unless --cache-key is given. It returns
+// the key, the absolute app path if given and the remaining arguments.
+func cacheKeyArgs(cacheKey string, args []string, n int) (string, string, []string, error) {
+	if cacheKey != "" {
+		if len(args) != n-1 {
+			return "", "", nil, fmt.Errorf("an app path cannot be used together with --cache-key")
+		}
+		return cacheKey, "", args, nil
+	}
+	if len(args) != n {
+		return "", "", nil, fmt.Errorf("missing app path or --cache-key")
+	}
+	appDir, err := filepath.Abs(args[0])
+	if err != nil {
+		return "", "", nil, err
+	}
+	return appDir, appDir, args[1:], nil
+}
+
+func gcCommand() *cobra.Command {
+	var dryRun bool
+	var minAge string
+	gcCommand := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove the containers, volumes and images left behind by interrupted builds",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			docker, err := docker.New(registryAuthFile)
+			if err != nil {
+				return err
+			}
+			age, err := parseAge(minAge)
+			if err != nil {
+				return err
+			}
+			gc := &pack.GC{Cli: docker, MinAge: age}
+			result, err := gc.Collect(context.Background(), dryRun)
+			if result != nil {
+				action := "removed"
+				if dryRun {
+					action = "would remove"
+				}
+				for _, kind := range []struct {
+					name string
+					ids  []string
+				}{
+					{"container", result.Containers},
+					{"volume", result.Volumes},
+					{"image", result.Images},
+				} {
+					for _, id := range kind.ids {
+						fmt.Printf("%s %s %s\n", action, kind.name, id)
+					}
+				}
+			}
+			return err
+		},
+	}
+	gcCommand.Flags().BoolVar(&dryRun, "dry-run", false, "only list the resources that would be removed")
+	gcCommand.Flags().StringVar(&minAge, "min-age", "1h", `only remove the leftovers of builds that created nothing for this long, e.g. "1h" or "2d"`)
+	return gcCommand
+}
+
+// signalContext returns a context that is cancelled when pack receives
+// SIGINT or SIGTERM, so that a build can clean up before exiting.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %s, cleaning up", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
+// imageClient returns an image client for the insecure registries of the pack
+// config and of --insecure-registry, with the credentials of
+// --registry-auth-file.
+func imageClient(cfg *config.Config) *image.Client {
+	return &image.Client{
+		InsecureRegistries: append(append([]string{}, cfg.InsecureRegistries...), insecureRegistries...),
+		Keychain:           docker.NewKeychain(registryAuthFile).Authn(),
+	}
+}
+
+func newCache() (*pack.Cache, error) {
+	docker, err := docker.New(registryAuthFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.New(filepath.Join(os.Getenv("HOME"), ".pack"))
+	if err != nil {
+		return nil, err
+	}
+	stack, err := cfg.Get("")
+	if err != nil {
+		return nil, err
+	}
+	if len(stack.BuildImages) == 0 {
+		return nil, fmt.Errorf(`Invalid stack: stack "%s" requies at least one build image`, stack.ID)
+	}
+	return &pack.Cache{
+		Cli:   docker,
+		Log:   log.New(os.Stdout, "", log.LstdFlags),
+		Image: stack.BuildImages[0],
+	}, nil
+}
+
+// parseAge parses a duration, additionally accepting a number of days such as "30d"
+func parseAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil {
+			return 0, fmt.Errorf(`invalid age "%s"`, age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid age "%s"`, age)
+	}
+	return d, nil
+}
+
+func humanSize(size int64) string {
+	if size < 0 {
+		return "N/A"
+	}
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
@@ -11,15 +11,19 @@ import (
 )
 
 type Config struct {
-	Stacks         []Stack `toml:"stacks"`
-	DefaultStackID string  `toml:"default-stack-id"`
-	configPath     string
+	Stacks             []Stack  `toml:"stacks"`
+	DefaultStackID     string   `toml:"default-stack-id"`
+	DefaultBuilder     string   `toml:"default-builder,omitempty"`
+	InsecureRegistries []string `toml:"insecure-registries,omitempty"`
+	DefaultPullPolicy  string   `toml:"default-pull-policy,omitempty"`
+	configPath         string
 }
 
 type Stack struct {
-	ID          string   `toml:"id"`
-	BuildImages []string `toml:"build-images"`
-	RunImages   []string `toml:"run-images"`
+	ID             string   `toml:"id"`
+	BuildImages    []string `toml:"build-images"`
+	RunImages      []string `toml:"run-images"`
+	DefaultBuilder string   `toml:"default-builder,omitempty"`
 }
 
 func New(path string) (*Config, error) {
@@ -107,6 +111,9 @@ func (c *Config) Update(stackID string, stack Stack) error {
 			if len(stack.RunImages) > 0 {
 				stk.RunImages = stack.RunImages
 			}
+			if stack.DefaultBuilder != "" {
+				stk.DefaultBuilder = stack.DefaultBuilder
+			}
 			c.Stacks[i] = stk
 			return c.save()
 		}
@@ -114,6 +121,134 @@ func (c *Config) Update(stackID string, stack Stack) error {
 	return fmt.Errorf(`Missing stack: stack with id "%s" not found in pack config.toml`, stackID)
 }
 
+func (c *Config) AddBuildImages(stackID string, images ...string) error {
+	return c.updateStack(stackID, func(stack *Stack) error {
+		stack.BuildImages = appendUnique(stack.BuildImages, images...)
+		return nil
+	})
+}
+
+func (c *Config) RemoveBuildImages(stackID string, images ...string) error {
+	return c.updateStack(stackID, func(stack *Stack) error {
+		var err error
+		stack.BuildImages, err = remove(stack.BuildImages, images, "build", stackID)
+		return err
+	})
+}
+
+func (c *Config) AddRunImages(stackID string, images ...string) error {
+	return c.updateStack(stackID, func(stack *Stack) error {
+		stack.RunImages = appendUnique(stack.RunImages, images...)
+		return nil
+	})
+}
+
+func (c *Config) RemoveRunImages(stackID string, images ...string) error {
+	return c.updateStack(stackID, func(stack *Stack) error {
+		var err error
+		stack.RunImages, err = remove(stack.RunImages, images, "run", stackID)
+		return err
+	})
+}
+
+// StackUpdate is a set of changes to the images of a stack. BuildImages and
+// RunImages replace the existing images when set, then the images to add are
+// added and the images to remove are removed.
+type StackUpdate struct {
+	BuildImages       []string
+	RunImages         []string
+	AddBuildImages    []string
+	RemoveBuildImages []string
+	AddRunImages      []string
+	RemoveRunImages   []string
+	DefaultBuilder    string
+	// UnsetDefaultBuilder removes the default builder of the stack
+	UnsetDefaultBuilder bool
+}
+
+// UpdateStack applies all of update to the stack and saves the config once,
+// leaving it untouched when any of the changes fails.
+func (c *Config) UpdateStack(stackID string, update StackUpdate) error {
+	return c.updateStack(stackID, func(stack *Stack) error {
+		if len(update.BuildImages) > 0 {
+			stack.BuildImages = update.BuildImages
+		}
+		if len(update.RunImages) > 0 {
+			stack.RunImages = update.RunImages
+		}
+		if update.DefaultBuilder != "" {
+			stack.DefaultBuilder = update.DefaultBuilder
+		}
+		if update.UnsetDefaultBuilder {
+			stack.DefaultBuilder = ""
+		}
+		stack.BuildImages = appendUnique(stack.BuildImages, update.AddBuildImages...)
+		stack.RunImages = appendUnique(stack.RunImages, update.AddRunImages...)
+		var err error
+		if len(update.RemoveBuildImages) > 0 {
+			if stack.BuildImages, err = remove(stack.BuildImages, update.RemoveBuildImages, "build", stackID); err != nil {
+				return err
+			}
+		}
+		if len(update.RemoveRunImages) > 0 {
+			if stack.RunImages, err = remove(stack.RunImages, update.RemoveRunImages, "run", stackID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Config) updateStack(stackID string, fn func(stack *Stack) error) error {
+	for i := range c.Stacks {
+		if c.Stacks[i].ID == stackID {
+			stack := c.Stacks[i]
+			if err := fn(&stack); err != nil {
+				return err
+			}
+			c.Stacks[i] = stack
+			return c.save()
+		}
+	}
+	return fmt.Errorf(`Missing stack: stack with id "%s" not found in pack config.toml`, stackID)
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		if !contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func remove(list, values []string, kind, stackID string) ([]string, error) {
+	for _, v := range values {
+		if !contains(list, v) {
+			return nil, fmt.Errorf(`"%s" is not a %s image of stack "%s"`, v, kind, stackID)
+		}
+	}
+	var out []string
+	for _, v := range list {
+		if !contains(values, v) {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf(`cannot remove every %s image of stack "%s"`, kind, stackID)
+	}
+	return out, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) Delete(stackID string) error {
 	if c.DefaultStackID == stackID {
 		return fmt.Errorf(`%s cannot be deleted when it is the default stack. You can change your default stack by running "pack set-default-stack".`, stackID)
@@ -127,6 +262,44 @@ func (c *Config) Delete(stackID string) error {
 	return fmt.Errorf(`"%s" does not exist. Please pass in a valid stack ID.`, stackID)
 }
 
+func (c *Config) SetDefaultStack(stackID string) error {
+	for _, s := range c.Stacks {
+		if s.ID == stackID {
+			c.DefaultStackID = stackID
+			return c.save()
+		}
+	}
+	return fmt.Errorf(`"%s" does not exist. Please pass in a valid stack ID.`, stackID)
+}
+
+// Registries returns the registries hosting the stack's build and run images,
+// in the order in which they first appear.
+func (s *Stack) Registries() []string {
+	var registries []string
+	seen := map[string]bool{}
+	for _, i := range append(append([]string{}, s.BuildImages...), s.RunImages...) {
+		reg, err := Registry(i)
+		if err != nil || seen[reg] {
+			continue
+		}
+		seen[reg] = true
+		registries = append(registries, reg)
+	}
+	return registries
+}
+
+// SetDefaultBuilder sets the builder used when neither the build nor the
+// default stack names one, or unsets it when builder is empty.
+func (c *Config) SetDefaultBuilder(builder string) error {
+	c.DefaultBuilder = builder
+	return c.save()
+}
+
+func (c *Config) SetDefaultPullPolicy(policy string) error {
+	c.DefaultPullPolicy = policy
+	return c.save()
+}
+
 func ImageByRegistry(registry string, images []string) (string, error) {
 	if len(images) == 0 {
 		return "", errors.New("empty images")
@@ -156,6 +156,24 @@ default-stack-id = "some.user.provided.stack"
 				assertEq(t, subject.DefaultStackID, "io.buildpacks.stacks.bionic")
 			})
 		})
+
+		when("config.toml has insecure registries", func() {
+			it.Before(func() {
+				assertNil(t, ioutil.WriteFile(filepath.Join(tmpDir, "config.toml"), []byte(`
+insecure-registries = ["registry.local:5000", "10.0.0.5"]
+`), 0666))
+			})
+
+			it("reads and preserves them", func() {
+				subject, err := config.New(tmpDir)
+				assertNil(t, err)
+				assertEq(t, subject.InsecureRegistries, []string{"registry.local:5000", "10.0.0.5"})
+
+				b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+				assertNil(t, err)
+				assertContains(t, string(b), `insecure-registries = ["registry.local:5000", "10.0.0.5"]`)
+			})
+		})
 	})
 
 	when("Config#Get", func() {
@@ -322,6 +340,22 @@ default-stack-id = "stack-1"
 				assertEq(t, stack.BuildImages, []string{"packs/build-2"})
 				assertEq(t, stack.RunImages, []string{"packs/run-3"})
 			})
+
+			it("updates the default builder of the stack", func() {
+				err := subject.Update("my.stack", config.Stack{
+					DefaultBuilder: "some/builder",
+				})
+				assertNil(t, err)
+				stack, err := subject.Get("my.stack")
+				assertNil(t, err)
+				assertEq(t, stack.DefaultBuilder, "some/builder")
+				assertEq(t, stack.BuildImages, []string{"packs/build"})
+				assertEq(t, stack.RunImages, []string{"packs/run"})
+
+				b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+				assertNil(t, err)
+				assertContains(t, string(b), `default-builder = "some/builder"`)
+			})
 		})
 
 		when("stack to be updated is NOT in file", func() {
@@ -336,6 +370,168 @@ default-stack-id = "stack-1"
 		})
 	})
 
+	when("Config#AddRunImages and Config#AddBuildImages", func() {
+		var subject *config.Config
+		it.Before(func() {
+			assertNil(t, ioutil.WriteFile(filepath.Join(tmpDir, "config.toml"), []byte(`
+default-stack-id = "stack-1"
+[[stacks]]
+  id = "stack-1"
+[[stacks]]
+  id = "my.stack"
+	build-images = ["packs/build"]
+	run-images = ["packs/run", "gcr.io/packs/run"]
+`), 0666))
+			var err error
+			subject, err = config.New(tmpDir)
+			assertNil(t, err)
+		})
+
+		it("appends new images, skips duplicates and keeps the order", func() {
+			assertNil(t, subject.AddRunImages("my.stack", "registry.com/packs/run", "packs/run", "registry.com/packs/run"))
+			assertNil(t, subject.AddBuildImages("my.stack", "gcr.io/packs/build"))
+
+			stack, err := subject.Get("my.stack")
+			assertNil(t, err)
+			assertEq(t, stack.RunImages, []string{"packs/run", "gcr.io/packs/run", "registry.com/packs/run"})
+			assertEq(t, stack.BuildImages, []string{"packs/build", "gcr.io/packs/build"})
+
+			b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+			assertContains(t, string(b), `run-images = ["packs/run", "gcr.io/packs/run", "registry.com/packs/run"]`)
+			assertContains(t, string(b), `build-images = ["packs/build", "gcr.io/packs/build"]`)
+		})
+
+		it("errors when the stack does NOT exist", func() {
+			err := subject.AddRunImages("other.stack", "packs/run")
+			assertNotNil(t, err)
+			assertEq(t, err.Error(), `Missing stack: stack with id "other.stack" not found in pack config.toml`)
+		})
+	})
+
+	when("Config#RemoveRunImages and Config#RemoveBuildImages", func() {
+		var subject *config.Config
+		it.Before(func() {
+			assertNil(t, ioutil.WriteFile(filepath.Join(tmpDir, "config.toml"), []byte(`
+default-stack-id = "stack-1"
+[[stacks]]
+  id = "stack-1"
+[[stacks]]
+  id = "my.stack"
+	build-images = ["packs/build", "gcr.io/packs/build"]
+	run-images = ["packs/run", "gcr.io/packs/run", "registry.com/packs/run"]
+`), 0666))
+			var err error
+			subject, err = config.New(tmpDir)
+			assertNil(t, err)
+		})
+
+		it("removes the images and keeps the order of the others", func() {
+			assertNil(t, subject.RemoveRunImages("my.stack", "gcr.io/packs/run"))
+			assertNil(t, subject.RemoveBuildImages("my.stack", "packs/build"))
+
+			stack, err := subject.Get("my.stack")
+			assertNil(t, err)
+			assertEq(t, stack.RunImages, []string{"packs/run", "registry.com/packs/run"})
+			assertEq(t, stack.BuildImages, []string{"gcr.io/packs/build"})
+
+			b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+			assertContains(t, string(b), `run-images = ["packs/run", "registry.com/packs/run"]`)
+		})
+
+		it("errors and leaves the stack unchanged when an image is not part of the stack", func() {
+			err := subject.RemoveRunImages("my.stack", "packs/run", "other/run")
+			assertNotNil(t, err)
+			assertEq(t, err.Error(), `"other/run" is not a run image of stack "my.stack"`)
+
+			stack, err := subject.Get("my.stack")
+			assertNil(t, err)
+			assertEq(t, stack.RunImages, []string{"packs/run", "gcr.io/packs/run", "registry.com/packs/run"})
+		})
+
+		it("errors and leaves the stack unchanged when every image would be removed", func() {
+			err := subject.RemoveBuildImages("my.stack", "packs/build", "gcr.io/packs/build")
+			assertNotNil(t, err)
+			assertEq(t, err.Error(), `cannot remove every build image of stack "my.stack"`)
+
+			stack, err := subject.Get("my.stack")
+			assertNil(t, err)
+			assertEq(t, stack.BuildImages, []string{"packs/build", "gcr.io/packs/build"})
+		})
+	})
+
+	when("Config#UpdateStack", func() {
+		var subject *config.Config
+		it.Before(func() {
+			assertNil(t, ioutil.WriteFile(filepath.Join(tmpDir, "config.toml"), []byte(`
+default-stack-id = "my.stack"
+[[stacks]]
+  id = "my.stack"
+	build-images = ["packs/build"]
+	run-images = ["packs/run", "gcr.io/packs/run"]
+`), 0666))
+			var err error
+			subject, err = config.New(tmpDir)
+			assertNil(t, err)
+		})
+
+		it("applies every change and saves them together", func() {
+			assertNil(t, subject.UpdateStack("my.stack", config.StackUpdate{
+				AddBuildImages:    []string{"gcr.io/packs/build"},
+				RemoveBuildImages: []string{"packs/build"},
+				RunImages:         []string{"registry.com/packs/run"},
+				DefaultBuilder:    "some/builder",
+			}))
+
+			stack, err := subject.Get("my.stack")
+			assertNil(t, err)
+			assertEq(t, stack.BuildImages, []string{"gcr.io/packs/build"})
+			assertEq(t, stack.RunImages, []string{"registry.com/packs/run"})
+			assertEq(t, stack.DefaultBuilder, "some/builder")
+
+			b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+			assertContains(t, string(b), `build-images = ["gcr.io/packs/build"]`)
+			assertContains(t, string(b), `run-images = ["registry.com/packs/run"]`)
+			assertContains(t, string(b), `default-builder = "some/builder"`)
+		})
+
+		it("unsets the default builder of the stack", func() {
+			assertNil(t, subject.UpdateStack("my.stack", config.StackUpdate{DefaultBuilder: "some/builder"}))
+			assertNil(t, subject.UpdateStack("my.stack", config.StackUpdate{UnsetDefaultBuilder: true}))
+
+			stack, err := subject.Get("my.stack")
+			assertNil(t, err)
+			assertEq(t, stack.DefaultBuilder, "")
+
+			b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+			if strings.Contains(string(b), "default-builder") {
+				t.Fatalf(`expected "default-builder" to be removed from config.toml: %s`, b)
+			}
+		})
+
+		it("errors and leaves the file unchanged when one of the changes fails", func() {
+			before, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+
+			err = subject.UpdateStack("my.stack", config.StackUpdate{
+				AddBuildImages:  []string{"gcr.io/packs/build"},
+				RemoveRunImages: []string{"packs/run", "gcr.io/packs/run"},
+			})
+			assertNotNil(t, err)
+			assertEq(t, err.Error(), `cannot remove every run image of stack "my.stack"`)
+
+			after, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+			assertEq(t, string(after), string(before))
+			stack, err := subject.Get("my.stack")
+			assertNil(t, err)
+			assertEq(t, stack.BuildImages, []string{"packs/build"})
+		})
+	})
+
 	when("Config#Delete", func() {
 		var subject *config.Config
 		it.Before(func() {
@@ -389,6 +585,95 @@ default-stack-id = "stack-1"
 		})
 	})
 
+	when("Config#SetDefaultStack", func() {
+		var subject *config.Config
+		it.Before(func() {
+			assertNil(t, ioutil.WriteFile(filepath.Join(tmpDir, "config.toml"), []byte(`
+default-stack-id = "stack-1"
+[[stacks]]
+  id = "stack-1"
+[[stacks]]
+  id = "my.stack"
+`), 0666))
+			var err error
+			subject, err = config.New(tmpDir)
+			assertNil(t, err)
+		})
+
+		when("the stack exists", func() {
+			it("sets the default-stack-id and writes the file", func() {
+				err := subject.SetDefaultStack("my.stack")
+				assertNil(t, err)
+				assertEq(t, subject.DefaultStackID, "my.stack")
+
+				b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+				assertNil(t, err)
+				assertContains(t, string(b), `default-stack-id = "my.stack"`)
+			})
+		})
+
+		when("the stack does NOT exist", func() {
+			it("errors and leaves the default-stack-id unchanged", func() {
+				err := subject.SetDefaultStack("other.stack")
+				assertNotNil(t, err)
+				assertEq(t, err.Error(), `"other.stack" does not exist. Please pass in a valid stack ID.`)
+				assertEq(t, subject.DefaultStackID, "stack-1")
+			})
+		})
+	})
+
+	when("Stack#Registries", func() {
+		it("returns each registry once in order of appearance, skipping non-parsable images", func() {
+			stack := config.Stack{
+				BuildImages: []string{"gcr.io/org/build", "as@ohd@as@op", "org/build"},
+				RunImages:   []string{"org/run", "registry.com/org/run", "gcr.io/org/run"},
+			}
+			assertEq(t, stack.Registries(), []string{"gcr.io", "index.docker.io", "registry.com"})
+		})
+
+		it("returns nothing when the stack has no images", func() {
+			stack := config.Stack{}
+			assertEq(t, stack.Registries(), []string(nil))
+		})
+	})
+
+	when("Config#SetDefaultBuilder", func() {
+		var subject *config.Config
+		it.Before(func() {
+			var err error
+			subject, err = config.New(tmpDir)
+			assertNil(t, err)
+		})
+
+		it("sets the default-builder and writes the file", func() {
+			b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+			if strings.Contains(string(b), "default-builder") {
+				t.Fatalf(`expected "default-builder" to be omitted from config.toml: %s`, b)
+			}
+
+			err = subject.SetDefaultBuilder("some/builder")
+			assertNil(t, err)
+			assertEq(t, subject.DefaultBuilder, "some/builder")
+
+			b, err = ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+			assertContains(t, string(b), `default-builder = "some/builder"`)
+		})
+
+		it("unsets the default-builder when the builder is empty", func() {
+			assertNil(t, subject.SetDefaultBuilder("some/builder"))
+			assertNil(t, subject.SetDefaultBuilder(""))
+			assertEq(t, subject.DefaultBuilder, "")
+
+			b, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.toml"))
+			assertNil(t, err)
+			if strings.Contains(string(b), "default-builder") {
+				t.Fatalf(`expected "default-builder" to be removed from config.toml: %s`, b)
+			}
+		})
+	})
+
 	when("ImageByRegistry", func() {
 		var images []string
 		it.Before(func() {
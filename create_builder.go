@@ -2,6 +2,7 @@ package pack
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,18 +17,27 @@ import (
 	"github.com/buildpack/pack/config"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/pkg/errors"
 )
 
 type BuilderConfig struct {
 	RepoName   string
+	StackID    string
 	Repo       img.Store
 	Buildpacks []Buildpack                `toml:"buildpacks"`
 	Groups     []lifecycle.BuildpackGroup `toml:"groups"`
 	BaseImage  v1.Image
 	BuilderDir string //original location of builder.toml, used for interpreting relative paths in buildpack URIs
+	// DigestFile receives the image ID of the builder, or its digest when
+	// UseDigest is set, which it must be when Repo is a registry or an OCI
+	// image layout since those keep the manifest
+	DigestFile string
+	UseDigest  bool
 }
 
 type Buildpack struct {
@@ -35,17 +45,44 @@ type Buildpack struct {
 	URI string
 }
 
+// BuilderMetadataLabel holds the BuilderMetadata of a builder image, so that
+// its contents can be read without pulling its layers.
+const BuilderMetadataLabel = "io.buildpacks.builder.metadata"
+
+type BuilderMetadata struct {
+	Buildpacks []BuilderBuildpack     `json:"buildpacks"`
+	Groups     []BuilderGroupMetadata `json:"groups"`
+}
+
+type BuilderGroupMetadata struct {
+	Buildpacks []BuilderBuildpack `json:"buildpacks"`
+}
+
+type BuilderBuildpack struct {
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"`
+	// Layer is the diff ID of the layer holding the buildpack
+	Layer string `json:"layer,omitempty"`
+}
+
 //go:generate mockgen -package mocks -destination mocks/docker.go github.com/buildpack/pack Docker
 type Docker interface {
-	PullImage(ref string) error
+	PullImage(ctx context.Context, ref string) error
 	RunContainer(ctx context.Context, id string, stdout io.Writer, stderr io.Writer) error
 	VolumeRemove(ctx context.Context, volumeID string, force bool) error
 	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error)
 	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
 	CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options types.CopyToContainerOptions) error
 	CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, types.ContainerPathStat, error)
-	ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
+	ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error)
 	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	VolumeCreate(ctx context.Context, options volume.VolumeCreateBody) (types.Volume, error)
+	VolumeInspect(ctx context.Context, volumeID string) (types.Volume, error)
+	VolumeList(ctx context.Context, filter filters.Args) (volume.VolumeListOKBody, error)
+	DiskUsage(ctx context.Context) (types.DiskUsage, error)
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
+	ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error)
 }
 
 //go:generate mockgen -package mocks -destination mocks/images.go github.com/buildpack/pack Images
@@ -64,8 +101,8 @@ type BuilderFactory struct {
 
 //go:generate mockgen -package mocks -destination mocks/fs.go github.com/buildpack/pack FS
 type FS interface {
-	CreateTGZFile(tarFile, srcDir, tarDir string, uid, gid int) error
-	CreateTarReader(srcDir, tarDir string, uid, gid int) (io.Reader, chan error)
+	CreateTGZFile(tarFile, srcDir, tarDir string, uid, gid int, excludes []string) error
+	CreateTarReader(srcDir, tarDir string, uid, gid int, excludes []string) (io.Reader, chan error)
 	Untar(r io.Reader, dest string) error
 	CreateSingleFileTar(path, txt string) (io.Reader, error)
 }
@@ -75,22 +112,45 @@ type CreateBuilderFlags struct {
 	BuilderTomlPath string
 	StackID         string
 	Publish         bool
-	NoPull          bool
+	// PullPolicy is one of always, if-not-present or never, defaults to
+	// default-pull-policy in the pack config
+	PullPolicy string
+	// OutputOCI and OutputTar write the builder to an OCI image layout
+	// directory or a docker save tarball instead of the daemon
+	OutputOCI string
+	OutputTar string
+	// DigestFile receives the digest of the builder when it is published or
+	// written to an OCI image layout, and its image ID otherwise
+	DigestFile string
 }
 
 func (f *BuilderFactory) BuilderConfigFromFlags(flags CreateBuilderFlags) (BuilderConfig, error) {
-	baseImage, err := f.baseImageName(flags.StackID, flags.RepoName)
+	if err := validateOutputFlags(flags.Publish, flags.OutputOCI, flags.OutputTar); err != nil {
+		return BuilderConfig{}, err
+	}
+	stack, err := f.Config.Get(flags.StackID)
 	if err != nil {
 		return BuilderConfig{}, err
 	}
-	if !flags.NoPull && !flags.Publish {
-		f.Log.Println("Pulling builder base image ", baseImage)
-		err := f.Docker.PullImage(baseImage)
-		if err != nil {
+	baseImage, err := f.baseImageName(stack, flags.RepoName)
+	if err != nil {
+		return BuilderConfig{}, err
+	}
+	pullPolicy, err := resolvePullPolicy(flags.PullPolicy, f.Config.DefaultPullPolicy)
+	if err != nil {
+		return BuilderConfig{}, err
+	}
+	if !flags.Publish {
+		if err := pullImage(context.Background(), f.Docker, f.Log, pullPolicy, "builder base image", baseImage); err != nil {
 			return BuilderConfig{}, fmt.Errorf(`failed to pull stack build image "%s": %s`, baseImage, err)
 		}
 	}
-	builderConfig := BuilderConfig{RepoName: flags.RepoName}
+	builderConfig := BuilderConfig{
+		RepoName:   flags.RepoName,
+		StackID:    stack.ID,
+		DigestFile: flags.DigestFile,
+		UseDigest:  flags.Publish || flags.OutputOCI != "",
+	}
 	_, err = toml.DecodeFile(flags.BuilderTomlPath, &builderConfig)
 	if err != nil {
 		return BuilderConfig{}, fmt.Errorf(`failed to decode builder config from file "%s": %s`, flags.BuilderTomlPath, err)
@@ -103,18 +163,18 @@ func (f *BuilderFactory) BuilderConfigFromFlags(flags CreateBuilderFlags) (Build
 	if builderConfig.BaseImage == nil {
 		return BuilderConfig{}, fmt.Errorf(`base image "%s" was not found`, baseImage)
 	}
-	builderConfig.Repo, err = f.Images.RepoStore(flags.RepoName, !flags.Publish)
+	if flags.OutputOCI != "" || flags.OutputTar != "" {
+		builderConfig.Repo, err = outputStore(flags.RepoName, flags.OutputOCI, flags.OutputTar)
+	} else {
+		builderConfig.Repo, err = f.Images.RepoStore(flags.RepoName, !flags.Publish)
+	}
 	if err != nil {
 		return BuilderConfig{}, fmt.Errorf(`failed to create repository store for builder image "%s": %s`, flags.RepoName, err)
 	}
 	return builderConfig, nil
 }
 
-func (f *BuilderFactory) baseImageName(stackID, repoName string) (string, error) {
-	stack, err := f.Config.Get(stackID)
-	if err != nil {
-		return "", err
-	}
+func (f *BuilderFactory) baseImageName(stack *config.Stack, repoName string) (string, error) {
 	if len(stack.BuildImages) == 0 {
 		return "", fmt.Errorf(`Invalid stack: stack "%s" requies at least one build image`, stack.ID)
 	}
@@ -140,19 +200,45 @@ func (f *BuilderFactory) Create(config BuilderConfig) error {
 	if err != nil {
 		return fmt.Errorf(`failed append order.toml layer to image: %s`, err)
 	}
+	metadata := BuilderMetadata{
+		Buildpacks: []BuilderBuildpack{},
+		Groups:     []BuilderGroupMetadata{},
+	}
 	for _, buildpack := range config.Buildpacks {
-		tarFile, err := f.buildpackLayer(tmpDir, buildpack, config.BuilderDir)
+		tarFile, version, err := f.buildpackLayer(tmpDir, buildpack, config.BuilderDir)
 		if err != nil {
 			return fmt.Errorf(`failed generate layer for buildpack "%s": %s`, buildpack.ID, err)
 		}
-		builderImage, _, err = img.Append(builderImage, tarFile)
+		var layer v1.Layer
+		builderImage, layer, err = img.Append(builderImage, tarFile)
 		if err != nil {
 			return fmt.Errorf(`failed append buildpack layer to image: %s`, err)
 		}
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return fmt.Errorf(`failed to calculate layer diff ID for buildpack "%s": %s`, buildpack.ID, err)
+		}
+		metadata.Buildpacks = append(metadata.Buildpacks, BuilderBuildpack{ID: buildpack.ID, Version: version, Layer: diffID.String()})
+	}
+	for _, group := range config.Groups {
+		g := BuilderGroupMetadata{Buildpacks: []BuilderBuildpack{}}
+		for _, bp := range group.Buildpacks {
+			g.Buildpacks = append(g.Buildpacks, BuilderBuildpack{ID: bp.ID, Version: bp.Version})
+		}
+		metadata.Groups = append(metadata.Groups, g)
+	}
+	builderImage, err = f.builderLabels(builderImage, config.StackID, metadata)
+	if err != nil {
+		return err
 	}
 	if err := config.Repo.Write(builderImage); err != nil {
 		return err
 	}
+	if config.DigestFile != "" {
+		if err := writeDigestFile(config.DigestFile, builderImage, config.UseDigest); err != nil {
+			return err
+		}
+	}
 
 	f.Log.Println("Successfully created builder image:", config.RepoName)
 	f.Log.Println("")
@@ -182,13 +268,39 @@ func (f *BuilderFactory) orderLayer(dest string, groups []lifecycle.BuildpackGro
 		return "", err
 	}
 	layerTar = filepath.Join(dest, "order.tar")
-	if err := f.FS.CreateTGZFile(layerTar, buildpackDir, "/buildpacks", 0, 0); err != nil {
+	if err := f.FS.CreateTGZFile(layerTar, buildpackDir, "/buildpacks", 0, 0, nil); err != nil {
 		return "", err
 	}
 	return layerTar, nil
 }
 
-func (f *BuilderFactory) buildpackLayer(dest string, buildpack Buildpack, builderDir string) (layerTar string, err error) {
+// builderLabels sets the stack ID and the builder metadata labels on the
+// builder image.
+func (f *BuilderFactory) builderLabels(builderImage v1.Image, stackID string, metadata BuilderMetadata) (v1.Image, error) {
+	configFile, err := builderImage.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read builder image config: %s`, err)
+	}
+	imageConfig := *configFile.Config.DeepCopy()
+	if imageConfig.Labels == nil {
+		imageConfig.Labels = map[string]string{}
+	}
+	if stackID != "" {
+		imageConfig.Labels["io.buildpacks.stack.id"] = stackID
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to marshal builder metadata: %s`, err)
+	}
+	imageConfig.Labels[BuilderMetadataLabel] = string(metadataJSON)
+	builderImage, err = mutate.Config(builderImage, imageConfig)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to set builder image labels: %s`, err)
+	}
+	return builderImage, nil
+}
+
+func (f *BuilderFactory) buildpackLayer(dest string, buildpack Buildpack, builderDir string) (layerTar, version string, err error) {
 	dir := strings.TrimPrefix(buildpack.URI, "file://")
 	if !filepath.IsAbs(dir) {
 		dir = filepath.Join(builderDir, dir)
@@ -201,18 +313,18 @@ func (f *BuilderFactory) buildpackLayer(dest string, buildpack Buildpack, builde
 	}
 	_, err = toml.DecodeFile(filepath.Join(dir, "buildpack.toml"), &data)
 	if err != nil {
-		return "", errors.Wrapf(err, "reading buildpack.toml from buildpack: %s", filepath.Join(dir, "buildpack.toml"))
+		return "", "", errors.Wrapf(err, "reading buildpack.toml from buildpack: %s", filepath.Join(dir, "buildpack.toml"))
 	}
 	bp := data.BP
 	if buildpack.ID != bp.ID {
-		return "", fmt.Errorf("buildpack ids did not match: %s != %s", buildpack.ID, bp.ID)
+		return "", "", fmt.Errorf("buildpack ids did not match: %s != %s", buildpack.ID, bp.ID)
 	}
 	if bp.Version == "" {
-		return "", fmt.Errorf("buildpack.toml must provide version: %s", filepath.Join(dir, "buildpack.toml"))
+		return "", "", fmt.Errorf("buildpack.toml must provide version: %s", filepath.Join(dir, "buildpack.toml"))
 	}
 	tarFile := filepath.Join(dest, fmt.Sprintf("%s.%s.tar", buildpack.ID, bp.Version))
-	if err := f.FS.CreateTGZFile(tarFile, dir, filepath.Join("/buildpacks", buildpack.ID, bp.Version), 0, 0); err != nil {
-		return "", err
+	if err := f.FS.CreateTGZFile(tarFile, dir, filepath.Join("/buildpacks", buildpack.ID, bp.Version), 0, 0, nil); err != nil {
+		return "", "", err
 	}
-	return tarFile, err
+	return tarFile, bp.Version, err
 }
@@ -2,8 +2,11 @@ package pack_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
@@ -12,6 +15,7 @@ import (
 	"github.com/buildpack/pack"
 	"github.com/buildpack/pack/config"
 	"github.com/buildpack/pack/fs"
+	"github.com/buildpack/pack/image"
 	"github.com/buildpack/pack/mocks"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
@@ -77,7 +81,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 			it("uses default stack build image as base image", func() {
 				mockBaseImage := mocks.NewMockImage(mockController)
 				mockImageStore := mocks.NewMockStore(mockController)
-				mockDocker.EXPECT().PullImage("default/build")
+				mockDocker.EXPECT().PullImage(gomock.Any(), "default/build")
 				mockImages.EXPECT().ReadImage("default/build", true).Return(mockBaseImage, nil)
 				mockImages.EXPECT().RepoStore("some/image", true).Return(mockImageStore, nil)
 
@@ -99,7 +103,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 			it("select the build image with matching registry", func() {
 				mockBaseImage := mocks.NewMockImage(mockController)
 				mockImageStore := mocks.NewMockStore(mockController)
-				mockDocker.EXPECT().PullImage("registry.com/build/image")
+				mockDocker.EXPECT().PullImage(gomock.Any(), "registry.com/build/image")
 				mockImages.EXPECT().ReadImage("registry.com/build/image", true).Return(mockBaseImage, nil)
 				mockImages.EXPECT().RepoStore("registry.com/some/image", true).Return(mockImageStore, nil)
 
@@ -118,7 +122,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 				assertEq(t, config.RepoName, "registry.com/some/image")
 			})
 
-			it("doesn't pull base a new image when --no-pull flag is provided", func() {
+			it("doesn't pull a new base image when the pull policy is never", func() {
 				mockBaseImage := mocks.NewMockImage(mockController)
 				mockImageStore := mocks.NewMockStore(mockController)
 				mockImages.EXPECT().ReadImage("default/build", true).Return(mockBaseImage, nil)
@@ -127,7 +131,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 				config, err := factory.BuilderConfigFromFlags(pack.CreateBuilderFlags{
 					RepoName:        "some/image",
 					BuilderTomlPath: filepath.Join("testdata", "builder.toml"),
-					NoPull:          true,
+					PullPolicy:      "never",
 				})
 				if err != nil {
 					t.Fatalf("error creating builder config: %s", err)
@@ -145,7 +149,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 				_, err := factory.BuilderConfigFromFlags(pack.CreateBuilderFlags{
 					RepoName:        "some/image",
 					BuilderTomlPath: filepath.Join("testdata", "builder.toml"),
-					NoPull:          true,
+					PullPolicy:      "never",
 				})
 				if err == nil {
 					t.Fatalf("Expected error when base image is missing from daemon")
@@ -153,7 +157,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 			})
 
 			it("fails if the base image cannot be pulled", func() {
-				mockDocker.EXPECT().PullImage("default/build").Return(fmt.Errorf("some-error"))
+				mockDocker.EXPECT().PullImage(gomock.Any(), "default/build").Return(fmt.Errorf("some-error"))
 
 				_, err := factory.BuilderConfigFromFlags(pack.CreateBuilderFlags{
 					RepoName:        "some/image",
@@ -176,7 +180,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 				_, err := factory.BuilderConfigFromFlags(pack.CreateBuilderFlags{
 					RepoName:        "some/image",
 					BuilderTomlPath: filepath.Join("testdata", "builder.toml"),
-					NoPull:          true,
+					PullPolicy:      "never",
 				})
 				assertError(t, err, `Invalid stack: stack "some.bad.stack" requies at least one build image`)
 			})
@@ -187,7 +191,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 				it("used the build image from the selected stack", func() {
 					mockBaseImage := mocks.NewMockImage(mockController)
 					mockImageStore := mocks.NewMockStore(mockController)
-					mockDocker.EXPECT().PullImage("other/build")
+					mockDocker.EXPECT().PullImage(gomock.Any(), "other/build")
 					mockImages.EXPECT().ReadImage("other/build", true).Return(mockBaseImage, nil)
 					mockImages.EXPECT().RepoStore("some/image", true).Return(mockImageStore, nil)
 
@@ -209,7 +213,7 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 					_, err := factory.BuilderConfigFromFlags(pack.CreateBuilderFlags{
 						RepoName:        "some/image",
 						BuilderTomlPath: filepath.Join("testdata", "builder.toml"),
-						NoPull:          true,
+						PullPolicy:      "never",
 						StackID:         "some.missing.stack",
 					})
 					assertError(t, err, `Missing stack: stack with id "some.missing.stack" not found in pack config.toml`)
@@ -240,6 +244,33 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("#BuilderConfigFromFlags with file outputs", func() {
+			it("writes to a tarball with --output-tar", func() {
+				mockBaseImage := mocks.NewMockImage(mockController)
+				mockImages.EXPECT().ReadImage("default/build", true).Return(mockBaseImage, nil)
+
+				config, err := factory.BuilderConfigFromFlags(pack.CreateBuilderFlags{
+					RepoName:        "some/image",
+					BuilderTomlPath: filepath.Join("testdata", "builder.toml"),
+					PullPolicy:      "never",
+					OutputTar:       "some/builder.tar",
+				})
+				assertNil(t, err)
+				_, ok := config.Repo.(*image.TarStore)
+				assertEq(t, ok, true)
+			})
+
+			it("fails when more than one destination is given", func() {
+				_, err := factory.BuilderConfigFromFlags(pack.CreateBuilderFlags{
+					RepoName:        "some/image",
+					BuilderTomlPath: filepath.Join("testdata", "builder.toml"),
+					Publish:         true,
+					OutputOCI:       "some/dir",
+				})
+				assertError(t, err, "only one of --publish, --output-oci and --output-tar may be used")
+			})
+		})
+
 		when.Focus("#Create", func() {
 			when("successful", func() {
 				it("logs usage tip", func() {
@@ -263,6 +294,104 @@ func testCreateBuilder(t *testing.T, when spec.G, it spec.S) {
 					assertContains(t, buf.String(), "Successfully created builder image: myorg/mybuilder")
 					assertContains(t, buf.String(), `Tip: Run "pack build <image name> --builder <builder image> --path <app source code>" to use this builder`)
 				})
+
+				it("sets the stack and builder metadata labels", func() {
+					buildpackDir, err := ioutil.TempDir("", "create-builder-test")
+					assertNil(t, err)
+					defer os.RemoveAll(buildpackDir)
+					assertNil(t, ioutil.WriteFile(filepath.Join(buildpackDir, "buildpack.toml"), []byte("[buildpack]\nid = \"some.bp\"\nversion = \"1.2.3\"\n"), 0666))
+
+					mockBaseImage := mocks.NewMockImage(mockController)
+					mockImageStore := mocks.NewMockStore(mockController)
+					mockBaseImage.EXPECT().Manifest().Return(&v1.Manifest{}, nil)
+					mockBaseImage.EXPECT().ConfigFile().Return(&v1.ConfigFile{}, nil)
+					var written v1.Image
+					mockImageStore.EXPECT().Write(gomock.Any()).Do(func(image v1.Image) { written = image })
+
+					err = factory.Create(pack.BuilderConfig{
+						RepoName:   "myorg/mybuilder",
+						StackID:    "some.stack.id",
+						Repo:       mockImageStore,
+						Buildpacks: []pack.Buildpack{{ID: "some.bp", URI: "file://" + buildpackDir}},
+						Groups: []lifecycle.BuildpackGroup{
+							{Buildpacks: []*lifecycle.Buildpack{{ID: "some.bp", Version: "1.2.3"}}},
+						},
+						BaseImage: mockBaseImage,
+					})
+					assertNil(t, err)
+
+					configFile, err := written.ConfigFile()
+					assertNil(t, err)
+					assertEq(t, configFile.Config.Labels["io.buildpacks.stack.id"], "some.stack.id")
+					var metadata pack.BuilderMetadata
+					assertNil(t, json.Unmarshal([]byte(configFile.Config.Labels[pack.BuilderMetadataLabel]), &metadata))
+					assertEq(t, metadata.Groups, []pack.BuilderGroupMetadata{
+						{Buildpacks: []pack.BuilderBuildpack{{ID: "some.bp", Version: "1.2.3"}}},
+					})
+					assertEq(t, len(metadata.Buildpacks), 1)
+					assertEq(t, metadata.Buildpacks[0].ID, "some.bp")
+					assertEq(t, metadata.Buildpacks[0].Version, "1.2.3")
+					assertEq(t, metadata.Buildpacks[0].Layer, configFile.RootFS.DiffIDs[1].String())
+				})
+
+				it("writes the image ID of the builder to the digest file", func() {
+					tmpDir, err := ioutil.TempDir("", "create-builder-test")
+					assertNil(t, err)
+					defer os.RemoveAll(tmpDir)
+
+					mockBaseImage := mocks.NewMockImage(mockController)
+					mockImageStore := mocks.NewMockStore(mockController)
+					mockBaseImage.EXPECT().Manifest().Return(&v1.Manifest{}, nil)
+					mockBaseImage.EXPECT().ConfigFile().Return(&v1.ConfigFile{}, nil)
+					var written v1.Image
+					mockImageStore.EXPECT().Write(gomock.Any()).Do(func(image v1.Image) { written = image })
+
+					err = factory.Create(pack.BuilderConfig{
+						RepoName:   "myorg/mybuilder",
+						Repo:       mockImageStore,
+						Buildpacks: []pack.Buildpack{},
+						Groups:     []lifecycle.BuildpackGroup{},
+						BaseImage:  mockBaseImage,
+						DigestFile: filepath.Join(tmpDir, "iid"),
+					})
+					assertNil(t, err)
+
+					imageID, err := written.ConfigName()
+					assertNil(t, err)
+					txt, err := ioutil.ReadFile(filepath.Join(tmpDir, "iid"))
+					assertNil(t, err)
+					assertEq(t, string(txt), imageID.String())
+				})
+
+				it("writes the digest of the builder to the digest file when UseDigest is set", func() {
+					tmpDir, err := ioutil.TempDir("", "create-builder-test")
+					assertNil(t, err)
+					defer os.RemoveAll(tmpDir)
+
+					mockBaseImage := mocks.NewMockImage(mockController)
+					mockImageStore := mocks.NewMockStore(mockController)
+					mockBaseImage.EXPECT().Manifest().Return(&v1.Manifest{}, nil)
+					mockBaseImage.EXPECT().ConfigFile().Return(&v1.ConfigFile{}, nil)
+					var written v1.Image
+					mockImageStore.EXPECT().Write(gomock.Any()).Do(func(image v1.Image) { written = image })
+
+					err = factory.Create(pack.BuilderConfig{
+						RepoName:   "myorg/mybuilder",
+						Repo:       mockImageStore,
+						Buildpacks: []pack.Buildpack{},
+						Groups:     []lifecycle.BuildpackGroup{},
+						BaseImage:  mockBaseImage,
+						DigestFile: filepath.Join(tmpDir, "digest"),
+						UseDigest:  true,
+					})
+					assertNil(t, err)
+
+					digest, err := written.Digest()
+					assertNil(t, err)
+					txt, err := ioutil.ReadFile(filepath.Join(tmpDir, "digest"))
+					assertNil(t, err)
+					assertEq(t, string(txt), digest.String())
+				})
 			})
 		})
 	})
@@ -0,0 +1,233 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// Keychain finds the registry credentials of the user in a Docker config
+// file, the way the docker CLI does: from the credential helper configured
+// for the registry in credHelpers, from the credsStore helper, or from auths.
+type Keychain struct {
+	path   string
+	once   sync.Once
+	config configFile
+	err    error
+}
+
+type configFile struct {
+	Auths       map[string]dockertypes.AuthConfig `json:"auths"`
+	CredsStore  string                            `json:"credsStore"`
+	CredHelpers map[string]string                 `json:"credHelpers"`
+}
+
+// NewKeychain returns a keychain for the Docker config file at path. When
+// path is empty it uses config.json in $DOCKER_CONFIG or ~/.docker, which may
+// not exist. The file is read when credentials are first needed, so that it
+// only fails the commands that use them.
+func NewKeychain(path string) *Keychain {
+	return &Keychain{path: path}
+}
+
+func (k *Keychain) load() error {
+	k.once.Do(func() {
+		k.config, k.err = readConfigFile(k.path)
+	})
+	return k.err
+}
+
+func readConfigFile(path string) (configFile, error) {
+	var config configFile
+	explicit := path != ""
+	if !explicit {
+		dir := os.Getenv("DOCKER_CONFIG")
+		if dir == "" {
+			dir = filepath.Join(os.Getenv("HOME"), ".docker")
+		}
+		path = filepath.Join(dir, "config.json")
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return config, nil
+	} else if err != nil {
+		return config, errors.Wrapf(err, "read docker config %s", path)
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return config, errors.Wrapf(err, "parse docker config %s", path)
+	}
+	return config, nil
+}
+
+// RegistryAuth returns the credentials for the registry of the image ref,
+// encoded for the X-Registry-Auth header of the Docker API. It returns an
+// empty string when there are no credentials for the registry.
+func (k *Keychain) RegistryAuth(ref string) (string, error) {
+	authConfig, err := k.Resolve(ref)
+	if err != nil || authConfig == nil {
+		return "", err
+	}
+	b, err := json.Marshal(authConfig)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// Resolve returns the credentials for the registry of the image ref, or nil
+// when there are none.
+func (k *Keychain) Resolve(ref string) (*dockertypes.AuthConfig, error) {
+	r, err := name.ParseReference(ref, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image name %s", ref)
+	}
+	return k.resolveRegistry(r.Context().RegistryStr())
+}
+
+// resolveRegistry returns the credentials for registry, as host[:port], or nil
+// when there are none.
+func (k *Keychain) resolveRegistry(registry string) (*dockertypes.AuthConfig, error) {
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	server := registry
+	if registry == name.DefaultRegistry {
+		server = dockerHubServer
+	}
+
+	helper := k.config.CredsStore
+	var helperKeys []string
+	for key := range k.config.CredHelpers {
+		helperKeys = append(helperKeys, key)
+	}
+	if keys := registryKeys(helperKeys, registry, server); len(keys) > 0 {
+		helper = k.config.CredHelpers[keys[0]]
+	}
+	if helper != "" {
+		authConfig, err := credentialHelperAuth(helper, server)
+		if err != nil || authConfig != nil {
+			return authConfig, err
+		}
+	}
+
+	var authKeys []string
+	for key := range k.config.Auths {
+		authKeys = append(authKeys, key)
+	}
+	for _, key := range registryKeys(authKeys, registry, server) {
+		authConfig := k.config.Auths[key]
+		if authConfig.Auth != "" {
+			userPass, err := base64.StdEncoding.DecodeString(authConfig.Auth)
+			if err != nil {
+				return nil, errors.Wrapf(err, "decode credentials for %s", key)
+			}
+			parts := strings.SplitN(string(userPass), ":", 2)
+			if len(parts) != 2 {
+				return nil, errors.Errorf("invalid credentials for %s", key)
+			}
+			authConfig.Username, authConfig.Password, authConfig.Auth = parts[0], parts[1], ""
+		}
+		if authConfig.Username == "" && authConfig.IdentityToken == "" {
+			continue
+		}
+		authConfig.ServerAddress = server
+		return &authConfig, nil
+	}
+	return nil, nil
+}
+
+// Authn returns k as a keychain of go-containerregistry, so that images are
+// read from and published to registries with the same credentials as they
+// are pulled with.
+func (k *Keychain) Authn() authn.Keychain {
+	return authnKeychain{k}
+}
+
+type authnKeychain struct {
+	keychain *Keychain
+}
+
+// Resolve returns basic credentials for the registry. Identity tokens, which
+// only the daemon can exchange for registry tokens, are ignored, so that the
+// registry is accessed anonymously.
+func (a authnKeychain) Resolve(registry name.Registry) (authn.Authenticator, error) {
+	authConfig, err := a.keychain.resolveRegistry(registry.RegistryStr())
+	if err != nil {
+		return nil, err
+	}
+	if authConfig == nil || authConfig.Username == "" {
+		return authn.Anonymous, nil
+	}
+	return &authn.Basic{Username: authConfig.Username, Password: authConfig.Password}, nil
+}
+
+// registryKeys returns the keys of auths or credHelpers that are for registry,
+// in the order they are tried: server, the key the docker CLI writes for the
+// registry, first, then the other keys that have the same host, sorted, so
+// that the same credentials are picked on every run.
+func registryKeys(keys []string, registry, server string) []string {
+	var exact, others []string
+	for _, key := range keys {
+		if key == server {
+			exact = append(exact, key)
+		} else if registryHost(key) == registry {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+	return append(exact, others...)
+}
+
+// registryHost returns the registry host of a key of auths or credHelpers,
+// which may be a URL like https://index.docker.io/v1/.
+func registryHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "docker.io", "registry-1.docker.io":
+		return name.DefaultRegistry
+	}
+	return host
+}
+
+// credentialHelperAuth runs docker-credential-<helper> get, which follows the
+// protocol of github.com/docker/docker-credential-helpers. It returns nil
+// when the helper has no credentials for server.
+func credentialHelperAuth(helper, server string) (*dockertypes.AuthConfig, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "get credentials for %s from docker-credential-%s: %s", server, helper, strings.TrimSpace(stdout.String()+stderr.String()))
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, errors.Wrapf(err, "parse credentials from docker-credential-%s", helper)
+	}
+	if creds.Username == "<token>" {
+		return &dockertypes.AuthConfig{IdentityToken: creds.Secret, ServerAddress: server}, nil
+	}
+	return &dockertypes.AuthConfig{Username: creds.Username, Password: creds.Secret, ServerAddress: server}, nil
+}
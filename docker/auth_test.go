@@ -0,0 +1,217 @@
+package docker_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildpack/pack/docker"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestKeychain(t *testing.T) {
+	spec.Run(t, "keychain", testKeychain, spec.Report(report.Terminal{}))
+}
+
+func testKeychain(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir  string
+		oldPath string
+	)
+
+	writeConfig := func(config string) string {
+		path := filepath.Join(tmpDir, "config.json")
+		assertNil(t, ioutil.WriteFile(path, []byte(config), 0600))
+		return path
+	}
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "pack.docker.auth.")
+		assertNil(t, err)
+
+		helper := "#!/bin/sh\n" +
+			"read server\n" +
+			"if [ \"$server\" = \"registry.example.com\" ]; then\n" +
+			"  echo '{\"ServerURL\":\"registry.example.com\",\"Username\":\"helper-user\",\"Secret\":\"helper-secret\"}'\n" +
+			"elif [ \"$server\" = \"https://index.docker.io/v1/\" ]; then\n" +
+			"  echo '{\"ServerURL\":\"https://index.docker.io/v1/\",\"Username\":\"<token>\",\"Secret\":\"some-token\"}'\n" +
+			"else\n" +
+			"  echo 'credentials not found in native keychain'\n" +
+			"  exit 1\n" +
+			"fi\n"
+		assertNil(t, ioutil.WriteFile(filepath.Join(tmpDir, "docker-credential-test"), []byte(helper), 0755))
+		oldPath = os.Getenv("PATH")
+		os.Setenv("PATH", tmpDir+string(os.PathListSeparator)+oldPath)
+	})
+
+	it.After(func() {
+		os.Setenv("PATH", oldPath)
+		os.RemoveAll(tmpDir)
+	})
+
+	when("#Resolve", func() {
+		it("decodes the credentials in auths", func() {
+			auth := base64.StdEncoding.EncodeToString([]byte("some-user:some:password"))
+			keychain := docker.NewKeychain(writeConfig(`{"auths": {"https://registry.example.com/v2/": {"auth": "` + auth + `"}}}`))
+
+			authConfig, err := keychain.Resolve("registry.example.com/some/image:tag")
+			assertNil(t, err)
+			assertEq(t, authConfig, &dockertypes.AuthConfig{
+				Username:      "some-user",
+				Password:      "some:password",
+				ServerAddress: "registry.example.com",
+			})
+		})
+
+		it("uses the credential helper configured for the registry", func() {
+			keychain := docker.NewKeychain(writeConfig(`{"credHelpers": {"registry.example.com": "test"}}`))
+
+			authConfig, err := keychain.Resolve("registry.example.com/some/image")
+			assertNil(t, err)
+			assertEq(t, authConfig, &dockertypes.AuthConfig{
+				Username:      "helper-user",
+				Password:      "helper-secret",
+				ServerAddress: "registry.example.com",
+			})
+		})
+
+		it("uses the credentials store with the docker hub server address", func() {
+			keychain := docker.NewKeychain(writeConfig(`{"credsStore": "test"}`))
+
+			authConfig, err := keychain.Resolve("some/image")
+			assertNil(t, err)
+			assertEq(t, authConfig, &dockertypes.AuthConfig{
+				IdentityToken: "some-token",
+				ServerAddress: "https://index.docker.io/v1/",
+			})
+		})
+
+		it("falls back to auths when the credentials store has no credentials", func() {
+			auth := base64.StdEncoding.EncodeToString([]byte("some-user:some-password"))
+			keychain := docker.NewKeychain(writeConfig(`{"credsStore": "test", "auths": {"other.example.com": {"auth": "` + auth + `"}}}`))
+
+			authConfig, err := keychain.Resolve("other.example.com/some/image")
+			assertNil(t, err)
+			assertEq(t, authConfig.Username, "some-user")
+		})
+
+		it("prefers the key the docker CLI writes over other keys for the same registry", func() {
+			hubAuth := base64.StdEncoding.EncodeToString([]byte("hub-user:hub-password"))
+			otherAuth := base64.StdEncoding.EncodeToString([]byte("other-user:other-password"))
+			path := writeConfig(`{"auths": {"docker.io": {"auth": "` + otherAuth + `"}, "https://index.docker.io/v1/": {"auth": "` + hubAuth + `"}, "index.docker.io": {"auth": "` + otherAuth + `"}}}`)
+
+			for i := 0; i < 20; i++ {
+				authConfig, err := docker.NewKeychain(path).Resolve("some/image")
+				assertNil(t, err)
+				assertEq(t, authConfig.Username, "hub-user")
+			}
+		})
+
+		it("picks the first of the other keys for the same registry in sorted order", func() {
+			firstAuth := base64.StdEncoding.EncodeToString([]byte("first-user:first-password"))
+			secondAuth := base64.StdEncoding.EncodeToString([]byte("second-user:second-password"))
+			path := writeConfig(`{"auths": {"https://registry.example.com/v2/": {"auth": "` + secondAuth + `"}, "http://registry.example.com": {"auth": "` + firstAuth + `"}}, "credHelpers": {"https://registry.example.com": "missing", "http://registry.example.com/": "test"}}`)
+
+			for i := 0; i < 20; i++ {
+				authConfig, err := docker.NewKeychain(path).Resolve("registry.example.com/some/image")
+				assertNil(t, err)
+				assertEq(t, authConfig.Username, "helper-user")
+			}
+
+			path = writeConfig(`{"auths": {"https://registry.example.com/v2/": {"auth": "` + secondAuth + `"}, "http://registry.example.com": {"auth": "` + firstAuth + `"}}}`)
+			for i := 0; i < 20; i++ {
+				authConfig, err := docker.NewKeychain(path).Resolve("registry.example.com/some/image")
+				assertNil(t, err)
+				assertEq(t, authConfig.Username, "first-user")
+			}
+		})
+
+		it("returns nil when there are no credentials for the registry", func() {
+			keychain := docker.NewKeychain(writeConfig(`{"auths": {"registry.example.com": {}}}`))
+
+			authConfig, err := keychain.Resolve("registry.example.com/some/image")
+			assertNil(t, err)
+			assertEq(t, authConfig, (*dockertypes.AuthConfig)(nil))
+		})
+	})
+
+	when("#RegistryAuth", func() {
+		it("encodes the credentials for the docker API", func() {
+			keychain := docker.NewKeychain(writeConfig(`{"credHelpers": {"registry.example.com": "test"}}`))
+
+			auth, err := keychain.RegistryAuth("registry.example.com/some/image")
+			assertNil(t, err)
+			b, err := base64.URLEncoding.DecodeString(auth)
+			assertNil(t, err)
+			var authConfig dockertypes.AuthConfig
+			assertNil(t, json.Unmarshal(b, &authConfig))
+			assertEq(t, authConfig.Username, "helper-user")
+		})
+	})
+
+	when("#Authn", func() {
+		it("resolves basic credentials for go-containerregistry", func() {
+			keychain := docker.NewKeychain(writeConfig(`{"credHelpers": {"registry.example.com": "test"}}`))
+
+			registry, err := name.NewRegistry("registry.example.com", name.WeakValidation)
+			assertNil(t, err)
+			auth, err := keychain.Authn().Resolve(registry)
+			assertNil(t, err)
+			assertEq(t, auth, &authn.Basic{Username: "helper-user", Password: "helper-secret"})
+		})
+
+		it("resolves anonymous credentials when there are none for the registry", func() {
+			keychain := docker.NewKeychain(writeConfig(`{}`))
+
+			registry, err := name.NewRegistry("registry.example.com", name.WeakValidation)
+			assertNil(t, err)
+			auth, err := keychain.Authn().Resolve(registry)
+			assertNil(t, err)
+			assertEq(t, auth, authn.Anonymous)
+		})
+	})
+
+	when("#NewKeychain", func() {
+		it("only reads the file when credentials are needed", func() {
+			keychain := docker.NewKeychain(writeConfig(`{not json`))
+
+			_, err := keychain.Resolve("registry.example.com/some/image")
+			if err == nil || !strings.Contains(err.Error(), "parse docker config") {
+				t.Fatalf("Expected a parse error, got %v", err)
+			}
+		})
+
+		it("errors when the given file does not exist", func() {
+			keychain := docker.NewKeychain(filepath.Join(tmpDir, "missing.json"))
+
+			_, err := keychain.Resolve("registry.example.com/some/image")
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+		})
+	})
+}
+
+func assertNil(t *testing.T, actual interface{}) {
+	t.Helper()
+	if actual != nil {
+		t.Fatalf("Expected nil: %s", actual)
+	}
+}
+
+func assertEq(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 
 	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -15,14 +16,20 @@ import (
 
 type Client struct {
 	*dockercli.Client
+	Keychain *Keychain
+	// PullOutput receives the progress of image pulls
+	PullOutput io.Writer
 }
 
-func New() (*Client, error) {
+// New returns a client for the daemon configured in the environment, which
+// authenticates pulls with the credentials from the Docker config file at
+// registryAuthFile, or the default Docker config file when it is empty.
+func New(registryAuthFile string) (*Client, error) {
 	cli, err := dockercli.NewEnvClient()
 	if err != nil {
 		return nil, errors.Wrap(err, "new docker client")
 	}
-	return &Client{cli}, nil
+	return &Client{Client: cli, Keychain: NewKeychain(registryAuthFile), PullOutput: os.Stdout}, nil
 }
 
 func (d *Client) RunContainer(ctx context.Context, id string, stdout io.Writer, stderr io.Writer) error {
@@ -74,19 +81,35 @@ func (d *Client) RunContainer(ctx context.Context, id string, stdout io.Writer,
 			return fmt.Errorf("failed with status code: %d", body.StatusCode)
 		}
 	case err := <-errChan:
-		fmt.Printf("ERR: %#v\n", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return err
 	}
 	return nil
 }
 
-func (d *Client) PullImage(ref string) error {
-	rc, err := d.ImagePull(context.Background(), ref, dockertypes.ImagePullOptions{})
+// PullImage pulls ref and displays its progress on PullOutput. The pull stops
+// when ctx is cancelled.
+func (d *Client) PullImage(ctx context.Context, ref string) error {
+	auth, err := d.Keychain.RegistryAuth(ref)
 	if err != nil {
 		return err
 	}
-	if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+	rc, err := d.ImagePull(ctx, ref, dockertypes.ImagePullOptions{RegistryAuth: auth})
+	if err != nil {
 		return err
 	}
-	return rc.Close()
+	defer rc.Close()
+	out := d.PullOutput
+	if out == nil {
+		out = ioutil.Discard
+	}
+	if err := DisplayPullStream(rc, out, isTerminal(out)); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.Wrapf(err, "pull %s", ref)
+	}
+	return nil
 }
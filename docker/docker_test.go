@@ -0,0 +1,81 @@
+package docker_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/buildpack/pack/docker"
+	dockercli "github.com/docker/docker/client"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestClient(t *testing.T) {
+	spec.Run(t, "client", testClient, spec.Report(report.Terminal{}))
+}
+
+func testClient(t *testing.T, when spec.G, it spec.S) {
+	when("#PullImage", func() {
+		var (
+			server     *httptest.Server
+			subject    *docker.Client
+			pulling    chan struct{}
+			configPath string
+		)
+
+		it.Before(func() {
+			pulling = make(chan struct{})
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasSuffix(r.URL.Path, "/images/create") {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(`{"status":"Pulling from some/image","id":"latest"}` + "\n"))
+				w.(http.Flusher).Flush()
+				close(pulling)
+				<-r.Context().Done()
+			}))
+
+			configPath = writeEmptyConfig(t)
+			cli, err := dockercli.NewClient("tcp://"+strings.TrimPrefix(server.URL, "http://"), "1.38", server.Client(), nil)
+			assertNil(t, err)
+			subject = &docker.Client{
+				Client:     cli,
+				Keychain:   docker.NewKeychain(configPath),
+				PullOutput: ioutil.Discard,
+			}
+		})
+
+		it.After(func() {
+			server.Close()
+			os.Remove(configPath)
+		})
+
+		it("stops the pull when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-pulling
+				cancel()
+			}()
+
+			if err := subject.PullImage(ctx, "some/image"); err != context.Canceled {
+				t.Fatalf("expected %v, got %v", context.Canceled, err)
+			}
+		})
+	})
+}
+
+func writeEmptyConfig(t *testing.T) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "pack.docker.config.")
+	assertNil(t, err)
+	defer f.Close()
+	_, err = f.Write([]byte(`{}`))
+	assertNil(t, err)
+	return f.Name()
+}
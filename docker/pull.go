@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Nvveen/Gotty"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/pkg/errors"
+)
+
+// pullSummaryInterval is how often a summary line is printed while pulling
+// when the output is not a terminal.
+var pullSummaryInterval = 10 * time.Second
+
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+type pullLayer struct {
+	status   string
+	current  int64
+	total    int64
+	complete bool
+}
+
+// DisplayPullStream reads the JSON messages of an image pull and reports its
+// progress to out. On a terminal it is displayed the way the docker CLI does,
+// otherwise, or when the terminal can't be used, a summary of all the layers
+// is printed periodically. An error reported in the stream is returned.
+func DisplayPullStream(r io.Reader, out io.Writer, tty bool) error {
+	if tty && terminfoReadable() {
+		var fd uintptr
+		if f, ok := out.(*os.File); ok {
+			fd = f.Fd()
+		}
+		return jsonmessage.DisplayJSONMessagesStream(r, out, fd, true, nil)
+	}
+
+	d := &pullDisplay{out: out, layers: map[string]*pullLayer{}, lastSummary: time.Now()}
+	dec := json.NewDecoder(r)
+	for {
+		var msg pullMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "read pull progress")
+		}
+		if msg.Error != "" || msg.ErrorDetail.Message != "" {
+			if msg.ErrorDetail.Message != "" {
+				return errors.New(msg.ErrorDetail.Message)
+			}
+			return errors.New(msg.Error)
+		}
+		d.update(msg)
+	}
+	if len(d.ids) > 0 {
+		d.summary()
+	}
+	return nil
+}
+
+// terminfoReadable reports whether jsonmessage can display the pull on the
+// terminal. The Gotty version it uses to read the terminfo entry panics on
+// some of them, such as xterm on recent distributions, and returns no entry
+// and no error when TERMINFO is set, which panics later on.
+func terminfoReadable() (ok bool) {
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "vt102"
+	}
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	info, err := gotty.OpenTermInfo(term)
+	return err != nil || info != nil
+}
+
+type pullDisplay struct {
+	out         io.Writer
+	ids         []string
+	layers      map[string]*pullLayer
+	lastSummary time.Time
+}
+
+func (d *pullDisplay) update(msg pullMessage) {
+	if msg.ID == "" || strings.HasPrefix(msg.Status, "Pulling from ") {
+		// Pulling from <repo>, Digest: <digest>, Status: Downloaded newer image
+		fmt.Fprintln(d.out, strings.TrimSpace(msg.ID+" "+msg.Status))
+		return
+	}
+
+	layer, ok := d.layers[msg.ID]
+	if !ok {
+		layer = &pullLayer{}
+		d.layers[msg.ID] = layer
+		d.ids = append(d.ids, msg.ID)
+	}
+	layer.status = msg.Status
+	if msg.ProgressDetail.Total > 0 {
+		layer.current, layer.total = msg.ProgressDetail.Current, msg.ProgressDetail.Total
+	}
+	switch msg.Status {
+	case "Download complete", "Pull complete", "Already exists":
+		layer.current = layer.total
+	}
+	layer.complete = msg.Status == "Pull complete" || msg.Status == "Already exists"
+
+	if time.Since(d.lastSummary) >= pullSummaryInterval {
+		d.summary()
+	}
+}
+
+func (d *pullDisplay) summary() {
+	var current, total int64
+	complete := 0
+	for _, layer := range d.layers {
+		current += layer.current
+		total += layer.total
+		if layer.complete {
+			complete++
+		}
+	}
+	fmt.Fprintf(d.out, "%d/%d layers complete, %s/%s downloaded\n", complete, len(d.ids), formatBytes(current), formatBytes(total))
+	d.lastSummary = time.Now()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// isTerminal reports whether out is a terminal, on which the pull progress is
+// displayed like the docker CLI does.
+func isTerminal(out io.Writer) bool {
+	f, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,96 @@
+package docker_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/buildpack/pack/docker"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestDisplayPullStream(t *testing.T) {
+	spec.Run(t, "pull-stream", testDisplayPullStream, spec.Report(report.Terminal{}))
+}
+
+func testDisplayPullStream(t *testing.T, when spec.G, it spec.S) {
+	stream := `{"status":"Pulling from some/image","id":"latest"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"layer1"}
+{"status":"Already exists","progressDetail":{},"id":"layer2"}
+{"status":"Downloading","progressDetail":{"current":1024,"total":2048},"id":"layer1"}
+{"status":"Download complete","progressDetail":{},"id":"layer1"}
+{"status":"Pull complete","progressDetail":{},"id":"layer1"}
+{"status":"Digest: sha256:some-digest"}
+{"status":"Status: Downloaded newer image for some/image:latest"}
+`
+
+	when("the output is not a terminal", func() {
+		it("prints the status and a summary of the layers", func() {
+			var out bytes.Buffer
+			assertNil(t, docker.DisplayPullStream(strings.NewReader(stream), &out, false))
+
+			assertEq(t, out.String(), "latest Pulling from some/image\n"+
+				"Digest: sha256:some-digest\n"+
+				"Status: Downloaded newer image for some/image:latest\n"+
+				"2/2 layers complete, 2.0KB/2.0KB downloaded\n")
+		})
+	})
+
+	when("the output is a terminal", func() {
+		var term, terminfo string
+		it.Before(func() {
+			// a terminal without a terminfo entry, for which jsonmessage uses
+			// plain ANSI escape sequences
+			term, terminfo = os.Getenv("TERM"), os.Getenv("TERMINFO")
+			os.Setenv("TERM", "pack-test-terminal")
+			os.Unsetenv("TERMINFO")
+		})
+
+		it.After(func() {
+			os.Setenv("TERM", term)
+			os.Setenv("TERMINFO", terminfo)
+		})
+
+		it("displays the progress like the docker CLI", func() {
+			var out bytes.Buffer
+			assertNil(t, docker.DisplayPullStream(strings.NewReader(stream), &out, true))
+
+			if !strings.Contains(out.String(), "\x1b[2A") {
+				t.Fatalf("Expected the cursor to move up to the layer lines, got %q", out.String())
+			}
+			for _, line := range []string{
+				"latest: Pulling from some/image",
+				"layer1: Pull complete",
+				"layer2: Already exists",
+				"Digest: sha256:some-digest",
+				"Status: Downloaded newer image for some/image:latest",
+			} {
+				if !strings.Contains(out.String(), line) {
+					t.Fatalf("Expected %q in the output, got %q", line, out.String())
+				}
+			}
+		})
+
+		it("prints a summary when the terminfo entry can't be read", func() {
+			os.Setenv("TERMINFO", "/some/terminfo")
+			var out bytes.Buffer
+			assertNil(t, docker.DisplayPullStream(strings.NewReader(stream), &out, true))
+
+			assertEq(t, out.String(), "latest Pulling from some/image\n"+
+				"Digest: sha256:some-digest\n"+
+				"Status: Downloaded newer image for some/image:latest\n"+
+				"2/2 layers complete, 2.0KB/2.0KB downloaded\n")
+		})
+	})
+
+	it("returns the error reported in the stream", func() {
+		err := docker.DisplayPullStream(strings.NewReader(`{"status":"Pulling fs layer","id":"layer1"}
+{"errorDetail":{"message":"unauthorized: authentication required"},"error":"unauthorized: authentication required"}
+`), &bytes.Buffer{}, false)
+		if err == nil || err.Error() != "unauthorized: authentication required" {
+			t.Fatalf("Expected the stream error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package pack
+
+import (
+	"context"
+	"io"
+)
+
+// The parts of the volume exporter that are tested on their own from package
+// pack_test.
+
+var RebaseTar = rebaseTar
+
+var SetRunImage = setRunImage
+
+type WorkspaceLayer struct {
+	Name   string
+	Data   interface{}
+	HasDir bool
+}
+
+func BuildpackLayers(cli Docker, ctrID, bpID string) ([]WorkspaceLayer, error) {
+	e := &volumeExporter{ctx: context.Background(), cli: cli, ctrID: ctrID}
+	layers, err := e.buildpackLayers(bpID)
+	if err != nil {
+		return nil, err
+	}
+	result := []WorkspaceLayer{}
+	for _, l := range layers {
+		result = append(result, WorkspaceLayer{Name: l.name, Data: l.data, HasDir: l.hasDir})
+	}
+	return result, nil
+}
+
+func NewProgressReader(rc io.ReadCloser, name string, size int64, out io.Writer) io.ReadCloser {
+	return &progressReader{ReadCloser: rc, name: name, size: size, progress: &layerProgress{out: out}}
+}
@@ -11,309 +11,447 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/buildpack/pack/image"
-
-	"github.com/buildpack/pack/fs"
-
 	"github.com/BurntSushi/toml"
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/lifecycle/img"
+	"github.com/buildpack/pack/image"
 	"github.com/buildpack/packs"
-	dockertypes "github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
+	dockercli "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/pkg/errors"
 )
 
-func exportRegistry(group *lifecycle.BuildpackGroup, workspaceDir, repoName, stackName string, stdout, stderr io.Writer) (string, error) {
-	images := &image.Client{}
+// exportRegistry builds the image from the workspace volume mounted in the
+// container ctrID and the stack image on the registry, and pushes it. The
+// layers are streamed from the container instead of copying the workspace to
+// the host, and are uploaded concurrently.
+func exportRegistry(ctx context.Context, cli Docker, images Images, ctrID string, group *lifecycle.BuildpackGroup, repoName, stackName string, labels map[string]string, stdout io.Writer) (v1.Image, error) {
 	origImage, err := images.ReadImage(repoName, false)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	stackImage, err := images.ReadImage(stackName, false)
 	if err != nil || stackImage == nil {
-		return "", packs.FailErr(err, "get image for", stackName)
+		return nil, packs.FailErr(err, "get image for", stackName)
 	}
 
-	repoStore, err := img.NewRegistry(repoName)
+	repoStore, err := images.RepoStore(repoName, false)
 	if err != nil {
-		return "", packs.FailErr(err, "access", repoName)
+		return nil, err
 	}
 
-	tmpDir, err := ioutil.TempDir("", "lifecycle.exporter.layer")
+	exporter := &volumeExporter{
+		ctx:      ctx,
+		cli:      cli,
+		ctrID:    ctrID,
+		out:      stdout,
+		progress: &layerProgress{out: stdout},
+	}
+	newImage, err := exporter.Export(group, stackName, stackImage, origImage)
 	if err != nil {
-		return "", packs.FailErr(err, "create temp directory")
+		return nil, packs.FailErrCode(err, packs.CodeFailedBuild)
 	}
-	defer os.RemoveAll(tmpDir)
 
-	exporter := &lifecycle.Exporter{
-		Buildpacks: group.Buildpacks,
-		TmpDir:     tmpDir,
-		Out:        stdout,
-		Err:        stderr,
-	}
-	newImage, err := exporter.Export(
-		workspaceDir,
-		stackImage,
-		origImage,
-	)
+	newImage, err = addLabels(newImage, labels)
 	if err != nil {
-		return "", packs.FailErrCode(err, packs.CodeFailedBuild)
+		return nil, packs.FailErr(err, "add labels")
 	}
 
 	if err := repoStore.Write(newImage); err != nil {
-		return "", packs.FailErrCode(err, packs.CodeFailedUpdate, "write")
+		return nil, packs.FailErrCode(err, packs.CodeFailedUpdate, "write")
 	}
 
-	sha, err := newImage.Digest()
-	if err != nil {
-		return "", packs.FailErr(err, "calculating image digest")
-	}
+	return newImage, nil
+}
 
-	return sha.String(), nil
+// volumeExporter adds the layers of the workspace to the stack image, the
+// way lifecycle.Exporter does from a directory, by streaming them from a
+// container with the workspace volume mounted on /workspace.
+//
+// lifecycle.Exporter needs the workspace on the local file system, and
+// copying all of it out of the volume before uploading anything is what made
+// publishing slow. Diverging from it is acceptable because what the rest of
+// the lifecycle reads from the image is the same: layers with the same paths,
+// added in the same order, and the same metadata label, which is how
+// analyze, rebase and the next export find the layers. It has to be kept in
+// line with the version of the lifecycle in go.mod when that changes; the
+// daemon, OCI and tar exports still use lifecycle.Exporter.
+type volumeExporter struct {
+	ctx      context.Context
+	cli      Docker
+	ctrID    string
+	out      io.Writer
+	progress *layerProgress
 }
 
-func exportDaemon(cli Docker, buildpacks []string, workspaceVolume, repoName, runImage string, stdout io.Writer) error {
-	ctx := context.Background()
-	ctr, err := cli.ContainerCreate(ctx, &container.Config{
-		Image:      runImage,
-		User:       "root",
-		Entrypoint: []string{},
-		Cmd:        []string{"echo", "hi"},
-	}, &container.HostConfig{
-		Binds: []string{
-			workspaceVolume + ":/workspace",
-		},
-	}, nil, "")
+func (e *volumeExporter) Export(group *lifecycle.BuildpackGroup, stackName string, stackImage, origImage v1.Image) (v1.Image, error) {
+	runImageSHA, err := topLayerDiffID(stackImage)
 	if err != nil {
-		return errors.Wrap(err, "container create")
+		return nil, errors.Wrap(err, "read run image layers")
+	}
+	metadata := packs.BuildMetadata{
+		RunImage:   packs.RunImageMetadata{Name: stackName, SHA: runImageSHA},
+		Buildpacks: []packs.BuildpackMetadata{},
 	}
 
-	r, _, err := cli.CopyFromContainer(ctx, ctr.ID, "/workspace")
+	origMetadata, err := buildMetadata(origImage)
 	if err != nil {
-		return errors.Wrap(err, "copy from container")
+		return nil, errors.Wrap(err, "read metadata of previous image")
 	}
 
-	r2, layerChan, errChan := addDockerfileToTar(runImage, repoName, buildpacks, r)
-
-	res, err := cli.ImageBuild(ctx, r2, dockertypes.ImageBuildOptions{Tags: []string{repoName}})
-	if err != nil {
-		return errors.Wrap(err, "image build")
+	image := stackImage
+	if image, metadata.App.SHA, err = e.addLayer(image, "app"); err != nil {
+		return nil, err
 	}
-	defer res.Body.Close()
-	if _, err := parseImageBuildBody(res.Body, stdout); err != nil {
-		return errors.Wrap(err, "image build")
+	if image, metadata.Config.SHA, err = e.addLayer(image, "config"); err != nil {
+		return nil, err
 	}
-	res.Body.Close()
 
-	if err := <-errChan; err != nil {
-		return errors.Wrap(err, "modify tar to add dockerfile")
+	for _, bp := range group.Buildpacks {
+		layers, err := e.buildpackLayers(bp.ID)
+		if err != nil {
+			return nil, err
+		}
+		bpMetadata := packs.BuildpackMetadata{Key: bp.ID, Name: bp.Name, Layers: map[string]packs.LayerMetadata{}}
+		for _, layer := range layers {
+			var sha string
+			if layer.hasDir {
+				image, sha, err = e.addLayer(image, bp.ID+"/"+layer.name)
+			} else {
+				image, sha, err = e.reuseLayer(image, origImage, origMetadata, bp.ID, layer.name)
+			}
+			if err != nil {
+				return nil, err
+			}
+			bpMetadata.Layers[layer.name] = packs.LayerMetadata{SHA: sha, Data: layer.data}
+		}
+		metadata.Buildpacks = append(metadata.Buildpacks, bpMetadata)
 	}
-	layerNames := <-layerChan
 
-	// Calculate metadata
-	i, _, err := cli.ImageInspectWithRaw(ctx, repoName)
+	metadataJSON, err := json.Marshal(metadata)
 	if err != nil {
-		return errors.Wrap(err, "inspect image to find layers")
+		return nil, errors.Wrap(err, "marshal metadata to json")
 	}
-	layerIDX := len(i.RootFS.Layers) - len(layerNames)
-	metadata := packs.BuildMetadata{
-		RunImage: packs.RunImageMetadata{
-			Name: runImage,
-			SHA:  i.RootFS.Layers[layerIDX-3],
-		},
-		App: packs.AppMetadata{
-			SHA: i.RootFS.Layers[layerIDX-2],
-		},
-		Config: packs.ConfigMetadata{
-			SHA: i.RootFS.Layers[layerIDX-1],
-		},
-		Buildpacks: []packs.BuildpackMetadata{},
+	return addLabels(image, map[string]string{lifecycle.MetadataLabel: string(metadataJSON)})
+}
+
+// addLayer adds the directory /workspace/<name> of the container as a layer.
+func (e *volumeExporter) addLayer(image v1.Image, name string) (v1.Image, string, error) {
+	layer, err := newVolumeLayer(e.ctx, e.cli, e.ctrID, "/workspace/"+name)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "create layer '%s'", name)
 	}
-	for _, buildpack := range buildpacks {
-		data := packs.BuildpackMetadata{Key: buildpack, Layers: make(map[string]packs.LayerMetadata)}
-		for _, layer := range layerNames {
-			if layer.buildpack == buildpack {
-				data.Layers[layer.layer] = packs.LayerMetadata{
-					SHA:  i.RootFS.Layers[layerIDX],
-					Data: layer.data,
-				}
-				layerIDX++
-			}
+	diffID, err := layer.DiffID()
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Fprintf(e.out, "adding layer '%s' with diffID '%s'\n", name, diffID)
+	image, err = mutate.AppendLayers(image, &progressLayer{Layer: layer, name: name, progress: e.progress})
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "append layer '%s'", name)
+	}
+	return image, diffID.String(), nil
+}
+
+// reuseLayer adds the layer of the previous image recorded in its metadata for
+// the layer name of the buildpack.
+func (e *volumeExporter) reuseLayer(image, origImage v1.Image, origMetadata packs.BuildMetadata, bpID, name string) (v1.Image, string, error) {
+	var sha string
+	for _, bp := range origMetadata.Buildpacks {
+		if bp.Key == bpID {
+			sha = bp.Layers[name].SHA
 		}
-		metadata.Buildpacks = append(metadata.Buildpacks, data)
 	}
-	metadataJSON, err := json.Marshal(metadata)
+	if origImage == nil || sha == "" {
+		return nil, "", fmt.Errorf("cannot reuse layer '%s/%s': not found in the previous image", bpID, name)
+	}
+	diffID, err := v1.NewHash(sha)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "parse diffID of layer '%s/%s'", bpID, name)
+	}
+	layer, err := origImage.LayerByDiffID(diffID)
 	if err != nil {
-		return errors.Wrap(err, "marshal metadata to json")
+		return nil, "", errors.Wrapf(err, "find layer '%s/%s' in the previous image", bpID, name)
 	}
-	if err := addLabelToImage(cli, repoName, map[string]string{lifecycle.MetadataLabel: string(metadataJSON)}, stdout); err != nil {
-		return errors.Wrapf(err, "adding %s label to image", lifecycle.MetadataLabel)
+	fmt.Fprintf(e.out, "reusing layer '%s/%s' with diffID '%s'\n", bpID, name, sha)
+	image, err = mutate.AppendLayers(image, layer)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "append layer '%s/%s'", bpID, name)
 	}
+	return image, sha, nil
+}
 
-	return nil
+type workspaceLayer struct {
+	name   string
+	data   interface{}
+	hasDir bool
 }
 
-func addLabelToImage(cli Docker, repoName string, labels map[string]string, stdout io.Writer) error {
-	dockerfile := "FROM " + repoName + "\n"
-	for k, v := range labels {
-		dockerfile += fmt.Sprintf("LABEL %s='%s'\n", k, v)
+// buildpackLayers lists the layers of a buildpack in the workspace, sorted by
+// name. A layer has a <name>.toml file, and a <name> directory unless it is
+// reused from the previous image.
+func (e *volumeExporter) buildpackLayers(bpID string) ([]workspaceLayer, error) {
+	rc, _, err := e.cli.CopyFromContainer(e.ctx, e.ctrID, "/workspace/"+bpID)
+	if dockercli.IsErrNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "read layers of buildpack '%s'", bpID)
 	}
-	f := &fs.FS{}
-	tr, err := f.CreateSingleFileTar("Dockerfile", dockerfile)
-	if err != nil {
-		return err
+	defer rc.Close()
+
+	layers := map[string]*workspaceLayer{}
+	layer := func(name string) *workspaceLayer {
+		if layers[name] == nil {
+			layers[name] = &workspaceLayer{name: name}
+		}
+		return layers[name]
+	}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "read layers of buildpack '%s'", bpID)
+		}
+		parts := strings.Split(strings.Trim(hdr.Name, "/"), "/")
+		if len(parts) != 2 {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			layer(parts[1]).hasDir = true
+		} else if name := strings.TrimSuffix(parts[1], ".toml"); name != parts[1] && name != "launch" {
+			data := map[string]interface{}{}
+			if _, err := toml.DecodeReader(tr, &data); err != nil {
+				return nil, errors.Wrapf(err, "read %s", hdr.Name)
+			}
+			layer(name).data = data
+		}
+	}
+
+	var result []workspaceLayer
+	for _, name := range sortedLayerNames(layers) {
+		if layers[name].data != nil {
+			result = append(result, *layers[name])
+		}
+	}
+	return result, nil
+}
+
+func sortedLayerNames(layers map[string]*workspaceLayer) []string {
+	var names []string
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// buildMetadata returns the metadata label of image, which may be nil.
+func buildMetadata(image v1.Image) (packs.BuildMetadata, error) {
+	var metadata packs.BuildMetadata
+	if image == nil {
+		return metadata, nil
 	}
-	res, err := cli.ImageBuild(context.Background(), tr, dockertypes.ImageBuildOptions{
-		Tags: []string{repoName},
-	})
+	configFile, err := image.ConfigFile()
 	if err != nil {
-		return err
+		return metadata, err
 	}
-	defer res.Body.Close()
-	if _, err := parseImageBuildBody(res.Body, stdout); err != nil {
-		return errors.Wrap(err, "image build")
+	label := configFile.Config.Labels[lifecycle.MetadataLabel]
+	if label == "" {
+		return metadata, nil
 	}
-	return err
+	err = json.Unmarshal([]byte(label), &metadata)
+	return metadata, err
 }
 
-type dockerfileLayer struct {
-	buildpack string
-	layer     string
-	data      interface{}
+// exportDaemon assembles the image from the workspace and the run image on
+// the daemon, reusing the layers of the previous image, and loads it into the
+// daemon with a single image load.
+func exportDaemon(ctx context.Context, cli Docker, images Images, group *lifecycle.BuildpackGroup, workspaceDir, repoName, runImage string, labels map[string]string, stdout, stderr io.Writer) (v1.Image, error) {
+	origImage, err := images.ReadImage(repoName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	stackImage, err := images.ReadImage(runImage, true)
+	if err != nil || stackImage == nil {
+		return nil, packs.FailErr(err, "get image for", runImage)
+	}
+
+	tag, err := name.NewTag(repoName, name.WeakValidation)
+	if err != nil {
+		return nil, packs.FailErr(err, "access", repoName)
+	}
+
+	return exportImage(group, workspaceDir, runImage, stackImage, origImage, &daemonStore{ctx: ctx, cli: cli, tag: tag}, labels, stdout, stderr)
 }
 
-func addDockerfileToTar(runImage, repoName string, buildpacks []string, r io.Reader) (io.Reader, chan []dockerfileLayer, chan error) {
-	dockerFile := "FROM " + runImage + "\n"
-	dockerFile += "ADD --chown=pack:pack /workspace/app /workspace/app\n"
-	dockerFile += "ADD --chown=pack:pack /workspace/config /workspace/config\n"
-	layerChan := make(chan []dockerfileLayer, 1)
-	var layerNames []dockerfileLayer
-	errChan := make(chan error, 1)
+// exportImage exports the workspace on top of the stack image, reusing the
+// layers of origImage when it is not nil, adds the labels and writes the
+// result to repoStore. It returns the new image.
+func exportImage(group *lifecycle.BuildpackGroup, workspaceDir, runImage string, stackImage, origImage v1.Image, repoStore img.Store, labels map[string]string, stdout, stderr io.Writer) (v1.Image, error) {
+	tmpDir, err := ioutil.TempDir("", "lifecycle.exporter.layer")
+	if err != nil {
+		return nil, packs.FailErr(err, "create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
 
-	pr, pw := io.Pipe()
-	tw := tar.NewWriter(pw)
+	exporter := &lifecycle.Exporter{
+		Buildpacks: group.Buildpacks,
+		TmpDir:     tmpDir,
+		Out:        stdout,
+		Err:        stderr,
+	}
+	newImage, err := exporter.Export(
+		workspaceDir,
+		stackImage,
+		origImage,
+	)
+	if err != nil {
+		return nil, packs.FailErrCode(err, packs.CodeFailedBuild)
+	}
 
-	isBuildpack := make(map[string]bool)
-	for _, b := range buildpacks {
-		isBuildpack[b] = true
+	newImage, err = setRunImage(newImage, runImage, stackImage)
+	if err != nil {
+		return nil, packs.FailErr(err, "set run image metadata")
 	}
 
-	go func() {
-		defer pw.Close()
-		tr := tar.NewReader(r)
-		tomlFiles := make(map[string]map[string]interface{})
-		dirs := make(map[string]map[string]bool)
-		for {
-			hdr, err := tr.Next()
-			if err == io.EOF {
-				break // End of archive
-			}
-			if err != nil {
-				layerChan <- nil
-				errChan <- errors.Wrap(err, "tr.Next")
-				return
-			}
+	newImage, err = addLabels(newImage, labels)
+	if err != nil {
+		return nil, packs.FailErr(err, "add labels")
+	}
 
-			tw.WriteHeader(hdr)
-
-			arr := strings.Split(strings.TrimPrefix(strings.TrimSuffix(hdr.Name, "/"), "/"), "/")
-			if len(arr) == 3 && isBuildpack[arr[1]] && strings.HasSuffix(arr[2], ".toml") && arr[2] != "launch.toml" {
-				if tomlFiles[arr[1]] == nil {
-					tomlFiles[arr[1]] = make(map[string]interface{})
-				}
-
-				buf, err := ioutil.ReadAll(tr)
-				if err != nil {
-					layerChan <- nil
-					errChan <- errors.Wrap(err, "read toml file")
-					return
-				}
-				if _, err := tw.Write(buf); err != nil {
-					layerChan <- nil
-					errChan <- errors.Wrap(err, "write toml file")
-					return
-				}
-
-				var data interface{}
-				if _, err := toml.Decode(string(buf), &data); err != nil {
-					layerChan <- nil
-					errChan <- errors.Wrap(err, "parsing toml file: "+hdr.Name)
-					return
-				}
-				tomlFiles[arr[1]][strings.TrimSuffix(arr[2], ".toml")] = data
-			} else if len(arr) == 3 && isBuildpack[arr[1]] && hdr.Typeflag == tar.TypeDir {
-				if dirs[arr[1]] == nil {
-					dirs[arr[1]] = make(map[string]bool)
-				}
-				dirs[arr[1]][arr[2]] = true
-			}
+	if err := repoStore.Write(newImage); err != nil {
+		return nil, packs.FailErrCode(err, packs.CodeFailedUpdate, "write")
+	}
 
-			// TODO is it OK to do this if we have already read it above? eg. toml file
-			if _, err := io.Copy(tw, tr); err != nil {
-				layerChan <- nil
-				errChan <- errors.Wrap(err, "io copy")
-				return
-			}
-		}
+	return newImage, nil
+}
 
-		copyFromPrev := false
-		for _, buildpack := range buildpacks {
-			layers := sortedKeys(tomlFiles[buildpack])
-			for _, layer := range layers {
-				layerNames = append(layerNames, dockerfileLayer{buildpack, layer, tomlFiles[buildpack][layer]})
-				if dirs[buildpack][layer] {
-					dockerFile += fmt.Sprintf("ADD --chown=pack:pack /workspace/%s/%s /workspace/%s/%s\n", buildpack, layer, buildpack, layer)
-				} else {
-					dockerFile += fmt.Sprintf("COPY --from=prev --chown=pack:pack /workspace/%s/%s /workspace/%s/%s\n", buildpack, layer, buildpack, layer)
-					copyFromPrev = true
-				}
-			}
-		}
-		if copyFromPrev {
-			dockerFile = "FROM " + repoName + " AS prev\n\n" + dockerFile
-		}
+// setRunImage records the name and top layer of the run image in the
+// metadata label, as lifecycle.Exporter only knows the run image as a
+// v1.Image and leaves the name empty.
+func setRunImage(image v1.Image, runImage string, stackImage v1.Image) (v1.Image, error) {
+	metadata, err := buildMetadata(image)
+	if err != nil {
+		return nil, err
+	}
+	metadata.RunImage.Name = runImage
+	if metadata.RunImage.SHA, err = topLayerDiffID(stackImage); err != nil {
+		return nil, err
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return addLabels(image, map[string]string{lifecycle.MetadataLabel: string(metadataJSON)})
+}
 
-		if err := tw.WriteHeader(&tar.Header{Name: "Dockerfile", Size: int64(len(dockerFile)), Mode: 0666}); err != nil {
-			layerChan <- nil
-			errChan <- errors.Wrap(err, "write tar header for Dockerfile")
-			return
-		}
-		if _, err := tw.Write([]byte(dockerFile)); err != nil {
-			layerChan <- nil
-			errChan <- errors.Wrap(err, "write Dockerfile to tar")
-			return
-		}
+func addLabels(image v1.Image, labels map[string]string) (v1.Image, error) {
+	if len(labels) == 0 {
+		return image, nil
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config := *configFile.Config.DeepCopy()
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	for k, v := range labels {
+		config.Labels[k] = v
+	}
+	return mutate.Config(image, config)
+}
+
+// daemonStore writes images to the daemon by streaming them, in the format of
+// docker save, to a single image load.
+type daemonStore struct {
+	ctx context.Context
+	cli Docker
+	tag name.Tag
+}
 
-		tw.Close()
-		pw.Close()
-		layerChan <- layerNames
-		errChan <- nil
+func (s *daemonStore) Ref() name.Reference {
+	return s.tag
+}
+
+func (s *daemonStore) Image() (v1.Image, error) {
+	return nil, errors.New("reading images from the daemon store is not supported")
+}
+
+func (s *daemonStore) Write(image v1.Image) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarball.Write(s.tag, image, nil, pw))
 	}()
+	res, err := s.cli.ImageLoad(s.ctx, pr, true)
+	if err != nil {
+		pr.CloseWithError(err)
+		return errors.Wrap(err, "image load")
+	}
+	defer res.Body.Close()
+	if err := parseJSONMessages(res.Body, ioutil.Discard); err != nil {
+		return errors.Wrap(err, "image load")
+	}
+	return nil
+}
 
-	return pr, layerChan, errChan
+// outputStore returns the store writing the image to the OCI image layout
+// directory or the tarball, whichever is set.
+func outputStore(repoName, ociDir, tarPath string) (img.Store, error) {
+	if ociDir != "" {
+		return image.NewOCILayoutStore(repoName, ociDir)
+	}
+	return image.NewTarStore(repoName, tarPath)
 }
 
-func sortedKeys(m map[string]interface{}) []string {
-	keys := make([]string, 0, len(m))
-	for key := range m {
-		keys = append(keys, key)
+// writeDigestFile writes the digest of image to path when useDigest is set,
+// which identifies it on a registry or in an OCI image layout, and its image
+// ID otherwise, which identifies it on the daemon.
+func writeDigestFile(path string, image v1.Image, useDigest bool) error {
+	id, err := image.ConfigName()
+	if useDigest {
+		id, err = image.Digest()
+	}
+	if err != nil {
+		return errors.Wrap(err, "calculate image digest")
 	}
-	sort.Strings(keys)
-	return keys
+	if err := ioutil.WriteFile(path, []byte(id.String()), 0644); err != nil {
+		return errors.Wrap(err, "write digest file")
+	}
+	return nil
 }
 
-func parseImageBuildBody(r io.Reader, out io.Writer) (string, error) {
+// validateOutputFlags checks that at most one destination other than the
+// daemon is set.
+func validateOutputFlags(publish bool, ociDir, tarPath string) error {
+	n := 0
+	for _, set := range []bool{publish, ociDir != "", tarPath != ""} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("only one of --publish, --output-oci and --output-tar may be used")
+	}
+	return nil
+}
+
+// parseJSONMessages prints the stream of JSON messages returned by the daemon
+// and returns the error it reports, if any.
+func parseJSONMessages(r io.Reader, out io.Writer) error {
 	jr := json.NewDecoder(r)
-	var id string
 	var streamError error
 	var obj struct {
 		Stream string `json:"stream"`
 		Error  string `json:"error"`
-		Aux    struct {
-			ID string `json:"ID"`
-		} `json:"aux"`
 	}
 	for {
 		err := jr.Decode(&obj)
@@ -321,10 +459,7 @@ func parseImageBuildBody(r io.Reader, out io.Writer) (string, error) {
 			if err == io.EOF {
 				break
 			}
-			return "", err
-		}
-		if obj.Aux.ID != "" {
-			id = obj.Aux.ID
+			return err
 		}
 		if txt := strings.TrimSpace(obj.Stream); txt != "" {
 			fmt.Fprintln(out, txt)
@@ -333,5 +468,5 @@ func parseImageBuildBody(r io.Reader, out io.Writer) (string, error) {
 			streamError = errors.New(txt)
 		}
 	}
-	return id, streamError
+	return streamError
 }
@@ -0,0 +1,150 @@
+package pack_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/pack"
+	"github.com/buildpack/pack/mocks"
+	"github.com/buildpack/packs"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestVolumeExporter(t *testing.T) {
+	spec.Run(t, "volume-exporter", testVolumeExporter, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testVolumeExporter(t *testing.T, when spec.G, it spec.S) {
+	var (
+		mockController *gomock.Controller
+		mockDocker     *mocks.MockDocker
+	)
+
+	it.Before(func() {
+		mockController = gomock.NewController(t)
+		mockDocker = mocks.NewMockDocker(mockController)
+	})
+
+	it.After(func() {
+		mockController.Finish()
+	})
+
+	when("#BuildpackLayers", func() {
+		for _, tc := range []struct {
+			desc     string
+			entries  []tarEntry
+			expected []pack.WorkspaceLayer
+		}{
+			{
+				desc: "returns the layers with a toml file, sorted by name",
+				entries: []tarEntry{
+					{Name: "some-bp", Typeflag: tar.TypeDir},
+					{Name: "some-bp/zlayer.toml", Typeflag: tar.TypeReg, Content: `key = "zval"`},
+					{Name: "some-bp/zlayer", Typeflag: tar.TypeDir},
+					{Name: "some-bp/alayer.toml", Typeflag: tar.TypeReg, Content: `key = "aval"`},
+					{Name: "some-bp/alayer", Typeflag: tar.TypeDir},
+				},
+				expected: []pack.WorkspaceLayer{
+					{Name: "alayer", Data: map[string]interface{}{"key": "aval"}, HasDir: true},
+					{Name: "zlayer", Data: map[string]interface{}{"key": "zval"}, HasDir: true},
+				},
+			},
+			{
+				desc: "returns a layer without a directory, which is reused from the previous image",
+				entries: []tarEntry{
+					{Name: "some-bp", Typeflag: tar.TypeDir},
+					{Name: "some-bp/reused.toml", Typeflag: tar.TypeReg, Content: `key = "val"`},
+				},
+				expected: []pack.WorkspaceLayer{
+					{Name: "reused", Data: map[string]interface{}{"key": "val"}, HasDir: false},
+				},
+			},
+			{
+				desc: "skips directories without a toml file, launch.toml and nested files",
+				entries: []tarEntry{
+					{Name: "some-bp", Typeflag: tar.TypeDir},
+					{Name: "some-bp/cache-only", Typeflag: tar.TypeDir},
+					{Name: "some-bp/launch.toml", Typeflag: tar.TypeReg, Content: `[[processes]]`},
+					{Name: "some-bp/launch", Typeflag: tar.TypeDir},
+					{Name: "some-bp/layer.toml", Typeflag: tar.TypeReg},
+					{Name: "some-bp/layer", Typeflag: tar.TypeDir},
+					{Name: "some-bp/layer/nested.toml", Typeflag: tar.TypeReg, Content: `key = "val"`},
+				},
+				expected: []pack.WorkspaceLayer{
+					{Name: "layer", Data: map[string]interface{}{}, HasDir: true},
+				},
+			},
+		} {
+			tc := tc
+			it(tc.desc, func() {
+				mockDocker.EXPECT().CopyFromContainer(gomock.Any(), "some-ctr", "/workspace/some-bp").
+					Return(ioutil.NopCloser(bytes.NewReader(makeTar(t, tc.entries))), dockertypes.ContainerPathStat{}, nil)
+
+				layers, err := pack.BuildpackLayers(mockDocker, "some-ctr", "some-bp")
+				assertNil(t, err)
+				assertEq(t, layers, tc.expected)
+			})
+		}
+
+		it("returns no layers when the buildpack has no directory", func() {
+			mockDocker.EXPECT().CopyFromContainer(gomock.Any(), "some-ctr", "/workspace/some-bp").
+				Return(nil, dockertypes.ContainerPathStat{}, notFoundError{})
+
+			layers, err := pack.BuildpackLayers(mockDocker, "some-ctr", "some-bp")
+			assertNil(t, err)
+			assertEq(t, layers, []pack.WorkspaceLayer{})
+		})
+
+		it("fails on an invalid toml file", func() {
+			mockDocker.EXPECT().CopyFromContainer(gomock.Any(), "some-ctr", "/workspace/some-bp").
+				Return(ioutil.NopCloser(bytes.NewReader(makeTar(t, []tarEntry{
+					{Name: "some-bp/layer.toml", Typeflag: tar.TypeReg, Content: `key = `},
+				}))), dockertypes.ContainerPathStat{}, nil)
+
+			_, err := pack.BuildpackLayers(mockDocker, "some-ctr", "some-bp")
+			assertNotNil(t, err)
+			assertContains(t, err.Error(), "read some-bp/layer.toml")
+		})
+	})
+	when("#SetRunImage", func() {
+		it("records the name and the top layer of the run image and keeps the rest of the metadata", func() {
+			runImage, err := random.Image(64, 2)
+			assertNil(t, err)
+			runConfig, err := runImage.ConfigFile()
+			assertNil(t, err)
+			appImage, err := random.Image(64, 1)
+			assertNil(t, err)
+			label, err := json.Marshal(packs.BuildMetadata{
+				App:        packs.AppMetadata{SHA: "sha256:app"},
+				Buildpacks: []packs.BuildpackMetadata{{Key: "some-bp", Layers: map[string]packs.LayerMetadata{"layer": {SHA: "sha256:layer"}}}},
+				RunImage:   packs.RunImageMetadata{SHA: "sha256:from-exporter"},
+			})
+			assertNil(t, err)
+			appImage, err = mutate.Config(appImage, v1.Config{Labels: map[string]string{lifecycle.MetadataLabel: string(label)}})
+			assertNil(t, err)
+
+			appImage, err = pack.SetRunImage(appImage, "some/run", runImage)
+			assertNil(t, err)
+
+			configFile, err := appImage.ConfigFile()
+			assertNil(t, err)
+			var metadata packs.BuildMetadata
+			assertNil(t, json.Unmarshal([]byte(configFile.Config.Labels[lifecycle.MetadataLabel]), &metadata))
+			assertEq(t, metadata, packs.BuildMetadata{
+				App:        packs.AppMetadata{SHA: "sha256:app"},
+				Buildpacks: []packs.BuildpackMetadata{{Key: "some-bp", Layers: map[string]packs.LayerMetadata{"layer": {SHA: "sha256:layer"}}}},
+				RunImage:   packs.RunImageMetadata{Name: "some/run", SHA: runConfig.RootFS.DiffIDs[1].String()},
+			})
+		})
+	})
+}
@@ -0,0 +1,131 @@
+package fs
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Excludes matches slash-separated paths, relative to the root of a
+// directory, against gitignore-style patterns.
+type Excludes struct {
+	patterns []excludePattern
+}
+
+type excludePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// NewExcludes compiles the patterns. Blank lines and lines starting with "#"
+// are skipped, "!" negates a pattern, a trailing "/" only matches
+// directories and a pattern containing any other "/" is anchored to the root.
+// When several patterns match a path, the last one wins.
+func NewExcludes(patterns []string) (*Excludes, error) {
+	e := &Excludes{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		var pattern excludePattern
+		if strings.HasPrefix(p, "!") {
+			pattern.negate = true
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			pattern.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		prefix := "^(.*/)?"
+		if strings.Contains(p, "/") {
+			prefix = "^"
+			p = strings.TrimPrefix(p, "/")
+		}
+		re, err := regexp.Compile(prefix + globToRegexp(p) + "$")
+		if err != nil {
+			return nil, err
+		}
+		pattern.re = re
+		e.patterns = append(e.patterns, pattern)
+	}
+	return e, nil
+}
+
+// Match reports whether the path is excluded.
+func (e *Excludes) Match(path string, isDir bool) bool {
+	if e == nil {
+		return false
+	}
+	excluded := false
+	for _, p := range e.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(path) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+func globToRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				re.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(regexp.QuoteMeta(glob[i:]))
+				return re.String()
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
+
+// ReadExcludesFile returns the lines of an ignore file, or nothing if the
+// file does not exist.
+func ReadExcludesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
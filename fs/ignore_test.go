@@ -0,0 +1,90 @@
+package fs_test
+
+import (
+	"testing"
+
+	"github.com/buildpack/pack/fs"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestExcludes(t *testing.T) {
+	spec.Run(t, "excludes", testExcludes, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testExcludes(t *testing.T, when spec.G, it spec.S) {
+	type match struct {
+		path     string
+		isDir    bool
+		excluded bool
+	}
+
+	check := func(patterns []string, matches []match) {
+		t.Helper()
+		excludes, err := fs.NewExcludes(patterns)
+		if err != nil {
+			t.Fatalf("NewExcludes failed: %s", err)
+		}
+		for _, m := range matches {
+			if excluded := excludes.Match(m.path, m.isDir); excluded != m.excluded {
+				t.Fatalf(`expected Match("%s", %t) with patterns %v to be %t`, m.path, m.isDir, patterns, m.excluded)
+			}
+		}
+	}
+
+	it("matches patterns without a slash at any depth", func() {
+		check([]string{"*.log", "node_modules"}, []match{
+			{"debug.log", false, true},
+			{"sub/dir/debug.log", false, true},
+			{"node_modules", true, true},
+			{"sub/node_modules", true, true},
+			{"app.js", false, false},
+		})
+	})
+
+	it("anchors patterns with a slash to the root", func() {
+		check([]string{"/build", "docs/*.md"}, []match{
+			{"build", true, true},
+			{"sub/build", true, false},
+			{"docs/readme.md", false, true},
+			{"docs/sub/readme.md", false, false},
+			{"sub/docs/readme.md", false, false},
+		})
+	})
+
+	it("only matches directories with a trailing slash", func() {
+		check([]string{"out/"}, []match{
+			{"out", true, true},
+			{"out", false, false},
+		})
+	})
+
+	it("supports double star patterns", func() {
+		check([]string{"**/tmp", "logs/**", "a/**/b"}, []match{
+			{"tmp", true, true},
+			{"x/y/tmp", true, true},
+			{"logs/x/y.log", false, true},
+			{"a/b", true, true},
+			{"a/x/y/b", true, true},
+		})
+	})
+
+	it("lets the last matching pattern win, including negations", func() {
+		check([]string{"*.md", "!README.md", "# comment", ""}, []match{
+			{"CHANGES.md", false, true},
+			{"README.md", false, false},
+			{"# comment", false, false},
+		})
+	})
+
+	it("supports character classes and single character wildcards", func() {
+		check([]string{"file[0-9].txt", "v?.tmp", "[!a]*.bak"}, []match{
+			{"file1.txt", false, true},
+			{"filex.txt", false, false},
+			{"v1.tmp", false, true},
+			{"v10.tmp", false, false},
+			{"b.bak", false, true},
+			{"a.bak", false, false},
+		})
+	})
+}
@@ -13,7 +13,7 @@ import (
 type FS struct {
 }
 
-func (*FS) CreateTGZFile(tarFile, srcDir, tarDir string, uid, gid int) error {
+func (*FS) CreateTGZFile(tarFile, srcDir, tarDir string, uid, gid int, excludes []string) error {
 	fh, err := os.Create(tarFile)
 	if err != nil {
 		return fmt.Errorf("create file for tar: %s", err)
@@ -21,16 +21,16 @@ func (*FS) CreateTGZFile(tarFile, srcDir, tarDir string, uid, gid int) error {
 	defer fh.Close()
 	gzw := gzip.NewWriter(fh)
 	defer gzw.Close()
-	return writeTarArchive(gzw, srcDir, tarDir, uid, gid)
+	return writeTarArchive(gzw, srcDir, tarDir, uid, gid, excludes)
 }
 
-func (*FS) CreateTarReader(srcDir, tarDir string, uid, gid int) (io.Reader, chan error) {
+func (*FS) CreateTarReader(srcDir, tarDir string, uid, gid int, excludes []string) (io.Reader, chan error) {
 	r, w := io.Pipe()
 	errChan := make(chan error, 1)
 
 	go func() {
 		defer w.Close()
-		err := writeTarArchive(w, srcDir, tarDir, uid, gid)
+		err := writeTarArchive(w, srcDir, tarDir, uid, gid, excludes)
 		w.Close()
 		errChan <- err
 	}()
@@ -52,7 +52,12 @@ func (*FS) CreateSingleFileTar(path, txt string) (io.Reader, error) {
 	return bytes.NewReader(buf.Bytes()), nil
 }
 
-func writeTarArchive(w io.Writer, srcDir, tarDir string, uid, gid int) error {
+func writeTarArchive(w io.Writer, srcDir, tarDir string, uid, gid int, excludes []string) error {
+	matcher, err := NewExcludes(excludes)
+	if err != nil {
+		return fmt.Errorf("parse exclude patterns: %s", err)
+	}
+
 	tw := tar.NewWriter(w)
 	defer tw.Close()
 
@@ -60,13 +65,19 @@ func writeTarArchive(w io.Writer, srcDir, tarDir string, uid, gid int) error {
 		if err != nil {
 			return err
 		}
-		if fi.Mode().IsDir() {
-			return nil
-		}
 		relPath, err := filepath.Rel(srcDir, file)
 		if err != nil {
 			return err
 		}
+		if relPath != "." && matcher.Match(filepath.ToSlash(relPath), fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsDir() {
+			return nil
+		}
 
 		var header *tar.Header
 		if fi.Mode()&os.ModeSymlink != 0 {
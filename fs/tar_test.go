@@ -3,6 +3,7 @@ package fs_test
 import (
 	"archive/tar"
 	"compress/gzip"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/buildpack/pack/fs"
+	"github.com/google/go-cmp/cmp"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 )
@@ -43,7 +45,7 @@ func testFS(t *testing.T, when spec.G, it spec.S) {
 
 	it("writes a tar to the dest dir", func() {
 		tarFile := filepath.Join(tmpDir, "some.tar")
-		err := fs.CreateTGZFile(tarFile, src, "/dir-in-archive", 1234, 2345)
+		err := fs.CreateTGZFile(tarFile, src, "/dir-in-archive", 1234, 2345, nil)
 		if err != nil {
 			t.Fatalf("CreateTGZFile failed: %s", err)
 		}
@@ -93,4 +95,46 @@ func testFS(t *testing.T, when spec.G, it spec.S) {
 			t.Fatalf(`expected to link-file to have atrget "../some-file.txt" got %s`, header.Linkname)
 		}
 	})
+	it("leaves out the excluded files and directories", func() {
+		srcDir := filepath.Join(tmpDir, "src")
+		for _, name := range []string{"app.js", "node_modules/dep/index.js", "build/out.txt", "docs/keep.md", "docs/skip.md"} {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(srcDir, name)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(srcDir, name), []byte("content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tarFile := filepath.Join(tmpDir, "some.tar")
+		err := fs.CreateTGZFile(tarFile, srcDir, "/dir-in-archive", 1234, 2345, []string{"node_modules/", "/build", "docs/*.md", "!docs/keep.md"})
+		if err != nil {
+			t.Fatalf("CreateTGZFile failed: %s", err)
+		}
+		file, err := os.Open(tarFile)
+		if err != nil {
+			t.Fatalf("could not open tar file %s: %s", tarFile, err)
+		}
+		defer file.Close()
+		gzr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("could not read gzip %s: %s", tarFile, err)
+		}
+		tr := tar.NewReader(gzr)
+
+		var names []string
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to get next file: %s", err)
+			}
+			names = append(names, header.Name)
+		}
+		if diff := cmp.Diff(names, []string{"/dir-in-archive/app.js", "/dir-in-archive/docs/keep.md"}); diff != "" {
+			t.Fatalf("unexpected files in tar: %s", diff)
+		}
+	})
 }
@@ -0,0 +1,133 @@
+package pack
+
+import (
+	"context"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/pkg/errors"
+)
+
+// defaultGCMinAge is how long ago a build must have last created a
+// container or volume before gc considers it abandoned.
+const defaultGCMinAge = time.Hour
+
+// GC finds the containers, volumes and images left behind by interrupted pack
+// commands, using the labels pack sets on everything it creates.
+type GC struct {
+	Cli Docker
+	// MinAge is how long ago a build must have last created a container or
+	// volume for its leftovers to be removed, defaults to one hour, so that
+	// builds in progress are never touched
+	MinAge time.Duration
+}
+
+type GCResult struct {
+	Containers []string `json:"containers"`
+	Volumes    []string `json:"volumes"`
+	Images     []string `json:"images"`
+}
+
+// Collect removes the exited pack containers of abandoned builds, the
+// workspace volumes of abandoned builds that no longer have containers and
+// the dangling images built by pack. A build is abandoned when none of its
+// containers is running and it created nothing for MinAge. With dryRun it
+// only returns them.
+func (g *GC) Collect(ctx context.Context, dryRun bool) (*GCResult, error) {
+	result := &GCResult{}
+	minAge := g.MinAge
+	if minAge == 0 {
+		minAge = defaultGCMinAge
+	}
+	cutoff := time.Now().Add(-minAge)
+
+	containers, err := g.Cli.ContainerList(ctx, dockertypes.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", packVersionLabel)),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list containers")
+	}
+	active := map[string]bool{}
+	for _, ctr := range containers {
+		if !isIdle(ctr) || time.Unix(ctr.Created, 0).After(cutoff) {
+			active[gcBuildID(ctr)] = true
+		}
+	}
+	hasContainers := map[string]bool{}
+	inUse := map[string]bool{}
+	for _, ctr := range containers {
+		if active[gcBuildID(ctr)] {
+			hasContainers[gcBuildID(ctr)] = true
+			for _, m := range ctr.Mounts {
+				inUse[m.Name] = true
+			}
+			continue
+		}
+		if !dryRun {
+			if err := g.Cli.ContainerRemove(ctx, ctr.ID, dockertypes.ContainerRemoveOptions{}); err != nil {
+				return result, errors.Wrapf(err, "remove container %s", ctr.ID)
+			}
+		}
+		result.Containers = append(result.Containers, ctr.ID)
+	}
+
+	volumes, err := g.Cli.VolumeList(ctx, filters.NewArgs(filters.Arg("label", packBuildIDLabel)))
+	if err != nil {
+		return result, errors.Wrap(err, "list volumes")
+	}
+	for _, v := range volumes.Volumes {
+		createdAt, err := time.Parse(time.RFC3339, v.CreatedAt)
+		if err != nil || createdAt.After(cutoff) || inUse[v.Name] || hasContainers[v.Labels[packBuildIDLabel]] {
+			continue
+		}
+		if !dryRun {
+			if err := g.Cli.VolumeRemove(ctx, v.Name, false); err != nil {
+				return result, errors.Wrapf(err, "remove volume %s", v.Name)
+			}
+		}
+		result.Volumes = append(result.Volumes, v.Name)
+	}
+
+	images, err := g.Cli.ImageList(ctx, dockertypes.ImageListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("dangling", "true"),
+			filters.Arg("label", packVersionLabel),
+		),
+	})
+	if err != nil {
+		return result, errors.Wrap(err, "list images")
+	}
+	for _, i := range images {
+		if !dryRun {
+			if _, err := g.Cli.ImageRemove(ctx, i.ID, dockertypes.ImageRemoveOptions{PruneChildren: true}); err != nil {
+				return result, errors.Wrapf(err, "remove image %s", i.ID)
+			}
+		}
+		result.Images = append(result.Images, i.ID)
+	}
+
+	return result, nil
+}
+
+// isIdle reports whether the container is not running, because it exited
+// or because it was never started. A container stays created when pack is
+// killed between creating and starting it, so only its age tells whether its
+// build is still in progress.
+func isIdle(ctr dockertypes.Container) bool {
+	switch ctr.State {
+	case "created", "exited", "dead":
+		return true
+	}
+	return false
+}
+
+// gcBuildID returns the ID of the build that created the container, or the
+// container ID for containers created outside of a build.
+func gcBuildID(ctr dockertypes.Container) string {
+	if id := ctr.Labels[packBuildIDLabel]; id != "" {
+		return id
+	}
+	return ctr.ID
+}
@@ -0,0 +1,154 @@
+package pack_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildpack/pack"
+	"github.com/buildpack/pack/mocks"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/golang/mock/gomock"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestGC(t *testing.T) {
+	spec.Run(t, "gc", testGC, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testGC(t *testing.T, when spec.G, it spec.S) {
+	var (
+		subject        *pack.GC
+		mockController *gomock.Controller
+		mockDocker     *mocks.MockDocker
+		old, recent    time.Time
+	)
+
+	buildLabels := func(buildID string) map[string]string {
+		return map[string]string{"io.buildpacks.pack.version": "dev", "io.buildpacks.pack.build-id": buildID}
+	}
+
+	it.Before(func() {
+		mockController = gomock.NewController(t)
+		mockDocker = mocks.NewMockDocker(mockController)
+		subject = &pack.GC{Cli: mockDocker}
+		old = time.Now().Add(-72 * time.Hour)
+		recent = time.Now().Add(-time.Minute)
+	})
+
+	it.After(func() {
+		mockController.Finish()
+	})
+
+	when("#Collect", func() {
+		when("builds are abandoned", func() {
+			it.Before(func() {
+				mockDocker.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]dockertypes.Container{
+					{ID: "running-ctr", State: "running", Created: old.Unix(), Labels: buildLabels("running"), Mounts: []dockertypes.MountPoint{{Name: "pack-workspace-running"}}},
+					{ID: "exited-ctr", State: "exited", Created: old.Unix(), Labels: buildLabels("abandoned")},
+				}, nil)
+				mockDocker.EXPECT().VolumeList(gomock.Any(), gomock.Any()).Return(volume.VolumeListOKBody{
+					Volumes: []*dockertypes.Volume{
+						{Name: "pack-workspace-running", CreatedAt: old.Format(time.RFC3339), Labels: buildLabels("running")},
+						{Name: "pack-workspace-abandoned", CreatedAt: old.Format(time.RFC3339), Labels: buildLabels("abandoned")},
+					},
+				}, nil)
+				mockDocker.EXPECT().ImageList(gomock.Any(), gomock.Any()).Return([]dockertypes.ImageSummary{
+					{ID: "sha256:dangling"},
+				}, nil)
+			})
+
+			it("removes the leftovers that are not used by running containers", func() {
+				mockDocker.EXPECT().ContainerRemove(gomock.Any(), "exited-ctr", gomock.Any())
+				mockDocker.EXPECT().VolumeRemove(gomock.Any(), "pack-workspace-abandoned", false)
+				mockDocker.EXPECT().ImageRemove(gomock.Any(), "sha256:dangling", gomock.Any())
+
+				result, err := subject.Collect(context.TODO(), false)
+				assertNil(t, err)
+				assertEq(t, result, &pack.GCResult{
+					Containers: []string{"exited-ctr"},
+					Volumes:    []string{"pack-workspace-abandoned"},
+					Images:     []string{"sha256:dangling"},
+				})
+			})
+
+			when("dry run", func() {
+				it("does not remove anything", func() {
+					result, err := subject.Collect(context.TODO(), true)
+					assertNil(t, err)
+					assertEq(t, result, &pack.GCResult{
+						Containers: []string{"exited-ctr"},
+						Volumes:    []string{"pack-workspace-abandoned"},
+						Images:     []string{"sha256:dangling"},
+					})
+				})
+			})
+		})
+
+		when("a build was killed before starting its containers", func() {
+			it("removes the created containers and the volume after MinAge", func() {
+				mockDocker.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]dockertypes.Container{
+					{ID: "export-ctr", State: "created", Created: old.Unix(), Labels: buildLabels("killed")},
+					{ID: "starting-ctr", State: "created", Created: recent.Unix(), Labels: buildLabels("starting")},
+				}, nil)
+				mockDocker.EXPECT().VolumeList(gomock.Any(), gomock.Any()).Return(volume.VolumeListOKBody{
+					Volumes: []*dockertypes.Volume{
+						{Name: "pack-workspace-killed", CreatedAt: old.Format(time.RFC3339), Labels: buildLabels("killed")},
+						{Name: "pack-workspace-starting", CreatedAt: old.Format(time.RFC3339), Labels: buildLabels("starting")},
+					},
+				}, nil)
+				mockDocker.EXPECT().ImageList(gomock.Any(), gomock.Any()).Return(nil, nil)
+				mockDocker.EXPECT().ContainerRemove(gomock.Any(), "export-ctr", gomock.Any())
+				mockDocker.EXPECT().VolumeRemove(gomock.Any(), "pack-workspace-killed", false)
+
+				result, err := subject.Collect(context.TODO(), false)
+				assertNil(t, err)
+				assertEq(t, result, &pack.GCResult{
+					Containers: []string{"export-ctr"},
+					Volumes:    []string{"pack-workspace-killed"},
+				})
+			})
+		})
+
+		when("a build is in progress", func() {
+			it.Before(func() {
+				mockDocker.EXPECT().ImageList(gomock.Any(), gomock.Any()).Return(nil, nil)
+			})
+
+			it("keeps the created and exited containers and the volume of a build with a running container", func() {
+				mockDocker.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]dockertypes.Container{
+					{ID: "build-ctr", State: "running", Created: old.Unix(), Labels: buildLabels("active")},
+					{ID: "detect-ctr", State: "exited", Created: old.Unix(), Labels: buildLabels("active")},
+					{ID: "export-ctr", State: "created", Created: old.Unix(), Labels: buildLabels("active")},
+				}, nil)
+				mockDocker.EXPECT().VolumeList(gomock.Any(), gomock.Any()).Return(volume.VolumeListOKBody{
+					Volumes: []*dockertypes.Volume{
+						{Name: "pack-workspace-active", CreatedAt: old.Format(time.RFC3339), Labels: buildLabels("active")},
+					},
+				}, nil)
+
+				result, err := subject.Collect(context.TODO(), false)
+				assertNil(t, err)
+				assertEq(t, result, &pack.GCResult{})
+			})
+
+			it("keeps the exited containers and the volumes of recent builds", func() {
+				mockDocker.EXPECT().ContainerList(gomock.Any(), gomock.Any()).Return([]dockertypes.Container{
+					{ID: "detect-ctr", State: "exited", Created: recent.Unix(), Labels: buildLabels("recent")},
+				}, nil)
+				mockDocker.EXPECT().VolumeList(gomock.Any(), gomock.Any()).Return(volume.VolumeListOKBody{
+					Volumes: []*dockertypes.Volume{
+						{Name: "pack-workspace-recent", CreatedAt: old.Format(time.RFC3339), Labels: buildLabels("recent")},
+						{Name: "pack-workspace-starting", CreatedAt: recent.Format(time.RFC3339), Labels: buildLabels("starting")},
+					},
+				}, nil)
+
+				result, err := subject.Collect(context.TODO(), false)
+				assertNil(t, err)
+				assertEq(t, result, &pack.GCResult{})
+			})
+		})
+	})
+}
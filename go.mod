@@ -2,6 +2,7 @@ module github.com/buildpack/pack
 
 require (
 	github.com/BurntSushi/toml v0.3.0
+	github.com/Nvveen/Gotty v0.0.0-20120604004816-cd527374f1e5
 	github.com/buildpack/lifecycle v0.0.0-20180824000627-1fe614565217b026a0fb5158241bb299b0da1a5e
 	github.com/buildpack/packs v0.0.0-20180824001031-aa30a412923763df37e83f14a6e4e0fe07e11f25
 	github.com/docker/docker v0.7.3-0.20180531152204-71cd53e4a197
@@ -0,0 +1,204 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+)
+
+// TarStore writes images as a tarball in the format of docker save, which
+// can be loaded with docker load.
+type TarStore struct {
+	tag  name.Tag
+	path string
+}
+
+func NewTarStore(repoName, path string) (*TarStore, error) {
+	tag, err := name.NewTag(repoName, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image name %s", repoName)
+	}
+	return &TarStore{tag: tag, path: path}, nil
+}
+
+func (s *TarStore) Ref() name.Reference {
+	return s.tag
+}
+
+func (s *TarStore) Image() (v1.Image, error) {
+	return tarball.ImageFromPath(s.path, &s.tag)
+}
+
+func (s *TarStore) Write(image v1.Image) error {
+	if err := tarball.WriteToFile(s.path, s.tag, image, nil); err != nil {
+		return errors.Wrapf(err, "write image to %s", s.path)
+	}
+	return nil
+}
+
+// OCILayoutStore writes images to a directory following the OCI image layout
+// specification. The image is added to index.json, annotated with its tag,
+// replacing any image with the same tag.
+type OCILayoutStore struct {
+	tag name.Tag
+	dir string
+}
+
+func NewOCILayoutStore(repoName, dir string) (*OCILayoutStore, error) {
+	tag, err := name.NewTag(repoName, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image name %s", repoName)
+	}
+	return &OCILayoutStore{tag: tag, dir: dir}, nil
+}
+
+const ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []v1.Descriptor `json:"manifests"`
+}
+
+func (s *OCILayoutStore) Ref() name.Reference {
+	return s.tag
+}
+
+func (s *OCILayoutStore) Image() (v1.Image, error) {
+	return nil, errors.New("reading images from an OCI layout is not supported")
+}
+
+func (s *OCILayoutStore) Write(image v1.Image) error {
+	blobsDir := filepath.Join(s.dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return errors.Wrapf(err, "create OCI layout %s", s.dir)
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		return errors.Wrap(err, "write oci-layout")
+	}
+
+	layers, err := image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "read image layers")
+	}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return errors.Wrap(err, "read layer digest")
+		}
+		if err := s.writeBlob(digest, layer.Compressed); err != nil {
+			return errors.Wrapf(err, "write layer %s", digest)
+		}
+	}
+
+	configName, err := image.ConfigName()
+	if err != nil {
+		return errors.Wrap(err, "read config digest")
+	}
+	config, err := image.RawConfigFile()
+	if err != nil {
+		return errors.Wrap(err, "read config")
+	}
+	if err := s.writeBytes(configName, config); err != nil {
+		return errors.Wrap(err, "write config")
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		return errors.Wrap(err, "read image digest")
+	}
+	manifest, err := image.RawManifest()
+	if err != nil {
+		return errors.Wrap(err, "read manifest")
+	}
+	if err := s.writeBytes(digest, manifest); err != nil {
+		return errors.Wrap(err, "write manifest")
+	}
+	mediaType, err := image.MediaType()
+	if err != nil {
+		return errors.Wrap(err, "read manifest media type")
+	}
+
+	return s.addToIndex(v1.Descriptor{
+		MediaType:   mediaType,
+		Size:        int64(len(manifest)),
+		Digest:      digest,
+		Annotations: map[string]string{ociRefNameAnnotation: s.tag.String()},
+	})
+}
+
+func (s *OCILayoutStore) addToIndex(desc v1.Descriptor) error {
+	indexPath := filepath.Join(s.dir, "index.json")
+	index := ociIndex{SchemaVersion: 2}
+	if b, err := ioutil.ReadFile(indexPath); err == nil {
+		if err := json.Unmarshal(b, &index); err != nil {
+			return errors.Wrapf(err, "parse %s", indexPath)
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "read %s", indexPath)
+	}
+
+	manifests := []v1.Descriptor{}
+	for _, m := range index.Manifests {
+		if m.Annotations[ociRefNameAnnotation] != desc.Annotations[ociRefNameAnnotation] {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, desc)
+
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(indexPath, b, 0644)
+}
+
+func (s *OCILayoutStore) writeBytes(digest v1.Hash, b []byte) error {
+	return s.writeBlob(digest, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	})
+}
+
+// writeBlob writes the blob to a temporary file next to it and renames it into
+// place once its digest is verified, so that a failed write never leaves a
+// partial blob that later writes would take for a complete one.
+func (s *OCILayoutStore) writeBlob(digest v1.Hash, open func() (io.ReadCloser, error)) error {
+	path := s.blobPath(digest)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := ioutil.TempFile(filepath.Dir(path), digest.Hex+".partial.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	actual, _, err := v1.SHA256(io.TeeReader(rc, f))
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if actual != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *OCILayoutStore) blobPath(digest v1.Hash) string {
+	return filepath.Join(s.dir, "blobs", digest.Algorithm, digest.Hex)
+}
@@ -0,0 +1,164 @@
+package image_test
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildpack/pack/image"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestFileStores(t *testing.T) {
+	spec.Run(t, "file-stores", testFileStores, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testFileStores(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir string
+		img    v1.Image
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "pack.image.file.")
+		assertNil(t, err)
+		img, err = random.Image(64, 2)
+		assertNil(t, err)
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("TarStore", func() {
+		it("writes an image that can be read back", func() {
+			store, err := image.NewTarStore("some/image:some-tag", filepath.Join(tmpDir, "image.tar"))
+			assertNil(t, err)
+			assertNil(t, store.Write(img))
+
+			read, err := store.Image()
+			assertNil(t, err)
+			expected, err := img.ConfigName()
+			assertNil(t, err)
+			actual, err := read.ConfigName()
+			assertNil(t, err)
+			assertEq(t, actual, expected)
+		})
+	})
+
+	when("OCILayoutStore", func() {
+		readIndex := func() map[string]interface{} {
+			b, err := ioutil.ReadFile(filepath.Join(tmpDir, "index.json"))
+			assertNil(t, err)
+			var index map[string]interface{}
+			assertNil(t, json.Unmarshal(b, &index))
+			return index
+		}
+
+		it("writes the layout, blobs and index", func() {
+			store, err := image.NewOCILayoutStore("some/image:some-tag", tmpDir)
+			assertNil(t, err)
+			assertNil(t, store.Write(img))
+
+			_, err = os.Stat(filepath.Join(tmpDir, "oci-layout"))
+			assertNil(t, err)
+			digest, err := img.Digest()
+			assertNil(t, err)
+			manifest, err := img.Manifest()
+			assertNil(t, err)
+			for _, blob := range append([]v1.Hash{digest, manifest.Config.Digest}, manifest.Layers[0].Digest, manifest.Layers[1].Digest) {
+				_, err := os.Stat(filepath.Join(tmpDir, "blobs", "sha256", blob.Hex))
+				assertNil(t, err)
+			}
+
+			manifests := readIndex()["manifests"].([]interface{})
+			assertEq(t, len(manifests), 1)
+			assertEq(t, manifests[0].(map[string]interface{})["digest"], digest.String())
+			assertEq(t, manifests[0].(map[string]interface{})["annotations"], map[string]interface{}{
+				"org.opencontainers.image.ref.name": "index.docker.io/some/image:some-tag",
+			})
+		})
+
+		it("replaces the image with the same tag in the index", func() {
+			store, err := image.NewOCILayoutStore("some/image:some-tag", tmpDir)
+			assertNil(t, err)
+			other, err := image.NewOCILayoutStore("some/image:other-tag", tmpDir)
+			assertNil(t, err)
+			newImg, err := random.Image(64, 1)
+			assertNil(t, err)
+
+			assertNil(t, store.Write(img))
+			assertNil(t, other.Write(img))
+			assertNil(t, store.Write(newImg))
+
+			manifests := readIndex()["manifests"].([]interface{})
+			assertEq(t, len(manifests), 2)
+			digest, err := newImg.Digest()
+			assertNil(t, err)
+			assertEq(t, manifests[1].(map[string]interface{})["digest"], digest.String())
+		})
+
+		it("leaves no blob behind when a layer does not match its digest", func() {
+			store, err := image.NewOCILayoutStore("some/image:some-tag", tmpDir)
+			assertNil(t, err)
+
+			err = store.Write(corruptImage{img})
+			if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+				t.Fatalf("Expected digest mismatch error, got: %v", err)
+			}
+
+			blobs, err := ioutil.ReadDir(filepath.Join(tmpDir, "blobs", "sha256"))
+			assertNil(t, err)
+			assertEq(t, len(blobs), 0)
+		})
+	})
+}
+
+// corruptImage is an image whose layers have content that does not match
+// their digests.
+type corruptImage struct {
+	v1.Image
+}
+
+func (i corruptImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	var corrupt []v1.Layer
+	for _, l := range layers {
+		corrupt = append(corrupt, corruptLayer{l})
+	}
+	return corrupt, nil
+}
+
+type corruptLayer struct {
+	v1.Layer
+}
+
+func (l corruptLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("corrupt")), nil
+}
+
+func assertNil(t *testing.T, actual interface{}) {
+	t.Helper()
+	if actual != nil {
+		t.Fatalf("Expected nil: %s", actual)
+	}
+}
+
+func assertEq(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Fatal(diff)
+	}
+}
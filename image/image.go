@@ -3,10 +3,18 @@ package image
 import (
 	"github.com/buildpack/lifecycle/img"
 	"github.com/buildpack/packs"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/v1"
 )
 
-type Client struct{}
+type Client struct {
+	// InsecureRegistries are the registries, as host[:port], that are reached
+	// over HTTP or without verifying their TLS certificate.
+	InsecureRegistries []string
+	// Keychain provides the credentials for the registries, which default to
+	// those of the default Docker config file
+	Keychain authn.Keychain
+}
 
 func (c *Client) ReadImage(repoName string, useDaemon bool) (v1.Image, error) {
 	repoStore, err := c.RepoStore(repoName, useDaemon)
@@ -29,9 +37,19 @@ func (c *Client) ReadImage(repoName string, useDaemon bool) (v1.Image, error) {
 }
 
 func (c *Client) RepoStore(repoName string, useDaemon bool) (img.Store, error) {
-	newRepoStore := img.NewRegistry
+	keychain := c.Keychain
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+	newRepoStore := func(repoName string) (img.Store, error) {
+		return NewRegistryStore(repoName, keychain)
+	}
 	if useDaemon {
 		newRepoStore = img.NewDaemon
+	} else if IsInsecureRegistry(repoName, c.InsecureRegistries) {
+		newRepoStore = func(repoName string) (img.Store, error) {
+			return NewInsecureRegistryStore(repoName, keychain)
+		}
 	}
 	repoStore, err := newRepoStore(repoName)
 	if err != nil {
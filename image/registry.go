@@ -0,0 +1,154 @@
+package image
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+)
+
+// RegistryStore reads and writes images on a registry with the credentials
+// of a keychain.
+type RegistryStore struct {
+	ref       name.Reference
+	keychain  authn.Keychain
+	transport http.RoundTripper
+}
+
+func NewRegistryStore(repoName string, keychain authn.Keychain) (*RegistryStore, error) {
+	ref, err := name.ParseReference(repoName, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image name %s", repoName)
+	}
+	return &RegistryStore{ref: ref, keychain: keychain, transport: http.DefaultTransport}, nil
+}
+
+func (s *RegistryStore) Ref() name.Reference {
+	return s.ref
+}
+
+func (s *RegistryStore) Image() (v1.Image, error) {
+	return remote.Image(s.ref, remote.WithAuthFromKeychain(s.keychain), remote.WithTransport(s.transport))
+}
+
+func (s *RegistryStore) Write(image v1.Image) error {
+	auth, err := s.keychain.Resolve(s.ref.Context().Registry)
+	if err != nil {
+		return errors.Wrapf(err, "resolve credentials for %s", s.ref.Context().RegistryStr())
+	}
+	if err := remote.Write(s.ref, image, auth, s.transport, remote.WriteOptions{}); err != nil {
+		return errors.Wrapf(err, "write image %s", s.ref)
+	}
+	return nil
+}
+
+// InsecureRegistryStore reads and writes images on a registry that may not
+// have a valid TLS certificate. Like the docker daemon, it talks to the
+// registry over HTTPS without verifying the certificate, and over plain HTTP
+// when the registry does not serve HTTPS.
+type InsecureRegistryStore struct {
+	RegistryStore
+}
+
+func NewInsecureRegistryStore(repoName string, keychain authn.Keychain) (*InsecureRegistryStore, error) {
+	ref, err := insecureReference(repoName)
+	if err != nil {
+		return nil, err
+	}
+	transport := &fallbackTransport{
+		registry: ref.Context().RegistryStr(),
+		inner: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	return &InsecureRegistryStore{RegistryStore{ref: ref, keychain: keychain, transport: transport}}, nil
+}
+
+// insecureReference parses repoName as a tag or a digest on a registry that
+// is reached over HTTP, which fallbackTransport upgrades to HTTPS when the
+// registry serves it.
+func insecureReference(repoName string) (name.Reference, error) {
+	ref, err := name.ParseReference(repoName, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image name %s", repoName)
+	}
+	registry, err := name.NewInsecureRegistry(ref.Context().RegistryStr(), name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse registry of %s", repoName)
+	}
+	switch r := ref.(type) {
+	case name.Tag:
+		r.Repository.Registry = registry
+		return r, nil
+	case name.Digest:
+		r.Repository.Registry = registry
+		return r, nil
+	}
+	return nil, errors.Errorf("unsupported image name %s", repoName)
+}
+
+// fallbackTransport sends the plain HTTP requests to registry over HTTPS
+// instead when the registry answers on HTTPS, which is checked once with a
+// request to /v2/.
+type fallbackTransport struct {
+	registry string
+	inner    http.RoundTripper
+
+	once   sync.Once
+	scheme string
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" || req.URL.Host != t.registry {
+		return t.inner.RoundTrip(req)
+	}
+	t.once.Do(func() {
+		t.scheme = "http"
+		if t.servesHTTPS() {
+			t.scheme = "https"
+		}
+	})
+	if t.scheme == "http" {
+		return t.inner.RoundTrip(req)
+	}
+	secure := new(http.Request)
+	*secure = *req
+	u := *req.URL
+	u.Scheme = t.scheme
+	secure.URL = &u
+	return t.inner.RoundTrip(secure)
+}
+
+func (t *fallbackTransport) servesHTTPS() bool {
+	req, err := http.NewRequest(http.MethodGet, "https://"+t.registry+"/v2/", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// IsInsecureRegistry reports whether the registry of repoName is one of
+// insecureRegistries, which are host[:port] names.
+func IsInsecureRegistry(repoName string, insecureRegistries []string) bool {
+	ref, err := name.ParseReference(repoName, name.WeakValidation)
+	if err != nil {
+		return false
+	}
+	for _, registry := range insecureRegistries {
+		if ref.Context().RegistryStr() == registry {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package image_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildpack/pack/image"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestInsecureRegistry(t *testing.T) {
+	spec.Run(t, "insecure-registry", testInsecureRegistry, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testInsecureRegistry(t *testing.T, when spec.G, it spec.S) {
+	when("#NewInsecureRegistryStore", func() {
+		it("uses plain HTTP for tags", func() {
+			store, err := image.NewInsecureRegistryStore("registry.local:5000/some/image:some-tag", authn.DefaultKeychain)
+			assertNil(t, err)
+			assertEq(t, store.Ref().Context().Registry.Scheme(), "http")
+			assertEq(t, store.Ref().String(), "registry.local:5000/some/image:some-tag")
+		})
+
+		it("uses plain HTTP for digests", func() {
+			store, err := image.NewInsecureRegistryStore("registry.local/some/image@sha256:0000000000000000000000000000000000000000000000000000000000000000", authn.DefaultKeychain)
+			assertNil(t, err)
+			assertEq(t, store.Ref().Context().Registry.Scheme(), "http")
+		})
+	})
+
+	when("InsecureRegistryStore#Image", func() {
+		var (
+			img     v1.Image
+			schemes []string
+			handler http.Handler
+		)
+
+		it.Before(func() {
+			var err error
+			img, err = random.Image(64, 1)
+			assertNil(t, err)
+			manifest, err := img.RawManifest()
+			assertNil(t, err)
+			schemes = nil
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.TLS != nil {
+					schemes = append(schemes, "https")
+				} else {
+					schemes = append(schemes, "http")
+				}
+				switch r.URL.Path {
+				case "/v2/":
+				case "/v2/some/image/manifests/some-tag":
+					w.Header().Set("Content-Type", string(types.DockerManifestSchema2))
+					w.Write(manifest)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+		})
+
+		it("uses HTTPS without verifying the certificate when the registry serves it", func() {
+			server := httptest.NewTLSServer(handler)
+			defer server.Close()
+
+			assertManifest(t, server.Listener.Addr().String(), img)
+			for _, scheme := range schemes {
+				assertEq(t, scheme, "https")
+			}
+		})
+
+		it("falls back to plain HTTP when the registry does not serve HTTPS", func() {
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			assertManifest(t, server.Listener.Addr().String(), img)
+			for _, scheme := range schemes {
+				assertEq(t, scheme, "http")
+			}
+		})
+
+		it("authenticates with the credentials of the keychain of the client", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if user, password, ok := r.BasicAuth(); !ok || user != "some-user" || password != "some-password" {
+					w.Header().Set("WWW-Authenticate", `Basic realm="some-registry"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			registry := server.Listener.Addr().String()
+
+			client := &image.Client{
+				InsecureRegistries: []string{registry},
+				Keychain:           staticKeychain{&authn.Basic{Username: "some-user", Password: "some-password"}},
+			}
+			actual, err := client.ReadImage(registry+"/some/image:some-tag", false)
+			assertNil(t, err)
+			if actual == nil {
+				t.Fatal("Expected the image to be read with the credentials")
+			}
+			actualDigest, err := actual.Digest()
+			assertNil(t, err)
+			digest, err := img.Digest()
+			assertNil(t, err)
+			assertEq(t, actualDigest, digest)
+		})
+	})
+
+	when("#IsInsecureRegistry", func() {
+		it("matches the registry host and port", func() {
+			insecure := []string{"registry.local:5000"}
+			assertEq(t, image.IsInsecureRegistry("registry.local:5000/some/image", insecure), true)
+			assertEq(t, image.IsInsecureRegistry("registry.local/some/image", insecure), false)
+			assertEq(t, image.IsInsecureRegistry("some/image", insecure), false)
+		})
+	})
+
+	when("Client#RepoStore", func() {
+		it("uses the insecure store for insecure registries", func() {
+			client := &image.Client{InsecureRegistries: []string{"registry.local:5000"}}
+			store, err := client.RepoStore("registry.local:5000/some/image", false)
+			assertNil(t, err)
+			if _, ok := store.(*image.InsecureRegistryStore); !ok {
+				t.Fatalf("Expected an insecure registry store, got %T", store)
+			}
+		})
+	})
+}
+
+func assertManifest(t *testing.T, registry string, expected v1.Image) {
+	t.Helper()
+	store, err := image.NewInsecureRegistryStore(registry+"/some/image:some-tag", authn.DefaultKeychain)
+	assertNil(t, err)
+	img, err := store.Image()
+	assertNil(t, err)
+	actual, err := img.Digest()
+	assertNil(t, err)
+	digest, err := expected.Digest()
+	assertNil(t, err)
+	assertEq(t, actual, digest)
+}
+
+type staticKeychain struct {
+	auth authn.Authenticator
+}
+
+func (k staticKeychain) Resolve(name.Registry) (authn.Authenticator, error) {
+	return k.auth, nil
+}
@@ -0,0 +1,107 @@
+package pack
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+type BuilderInspector struct {
+	Images Images
+}
+
+type BuilderInfo struct {
+	RepoName   string               `json:"image"`
+	StackID    string               `json:"stack"`
+	Buildpacks []BuilderBuildpack   `json:"buildpacks"`
+	Groups     [][]BuilderBuildpack `json:"groups"`
+}
+
+// Inspect reads the stack label and the buildpacks and detection order of a
+// builder image on the daemon or the registry. They come from the builder
+// metadata label when present, otherwise from /buildpacks/<id>/<version> and
+// /buildpacks/order.toml in the image.
+func (i *BuilderInspector) Inspect(repoName string, useDaemon bool) (*BuilderInfo, error) {
+	image, err := i.Images.ReadImage(repoName, useDaemon)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read builder image "%s": %s`, repoName, err)
+	}
+	if image == nil {
+		return nil, fmt.Errorf(`builder image "%s" was not found`, repoName)
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read config of builder image "%s": %s`, repoName, err)
+	}
+	info := &BuilderInfo{
+		RepoName:   repoName,
+		StackID:    configFile.Config.Labels["io.buildpacks.stack.id"],
+		Buildpacks: []BuilderBuildpack{},
+		Groups:     [][]BuilderBuildpack{},
+	}
+
+	if label := configFile.Config.Labels[BuilderMetadataLabel]; label != "" {
+		var metadata BuilderMetadata
+		if err := json.Unmarshal([]byte(label), &metadata); err != nil {
+			return nil, fmt.Errorf(`failed to parse label "%s" of builder image "%s": %s`, BuilderMetadataLabel, repoName, err)
+		}
+		info.Buildpacks = append(info.Buildpacks, metadata.Buildpacks...)
+		for _, group := range metadata.Groups {
+			info.Groups = append(info.Groups, group.Buildpacks)
+		}
+		return info, nil
+	}
+
+	rc := mutate.Extract(image)
+	defer rc.Close()
+	var order order
+	seen := map[BuilderBuildpack]bool{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf(`failed to read file system of builder image "%s": %s`, repoName, err)
+		}
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(hdr.Name, "./"), "/"), "/")
+		if len(parts) < 2 || parts[0] != "buildpacks" {
+			continue
+		}
+		if len(parts) == 2 && parts[1] == "order.toml" {
+			if _, err := toml.DecodeReader(tr, &order); err != nil {
+				return nil, fmt.Errorf(`failed to decode order.toml of builder image "%s": %s`, repoName, err)
+			}
+			continue
+		}
+		if len(parts) < 3 || (len(parts) == 3 && hdr.Typeflag == tar.TypeSymlink) {
+			continue
+		}
+		bp := BuilderBuildpack{ID: parts[1], Version: parts[2]}
+		if !seen[bp] {
+			seen[bp] = true
+			info.Buildpacks = append(info.Buildpacks, bp)
+		}
+	}
+	sort.Slice(info.Buildpacks, func(a, b int) bool {
+		if info.Buildpacks[a].ID == info.Buildpacks[b].ID {
+			return info.Buildpacks[a].Version < info.Buildpacks[b].Version
+		}
+		return info.Buildpacks[a].ID < info.Buildpacks[b].ID
+	})
+
+	for _, group := range order.Groups {
+		var buildpacks []BuilderBuildpack
+		for _, bp := range group.Buildpacks {
+			buildpacks = append(buildpacks, BuilderBuildpack{ID: bp.ID, Version: bp.Version})
+		}
+		info.Groups = append(info.Groups, buildpacks)
+	}
+	return info, nil
+}
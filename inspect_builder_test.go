@@ -0,0 +1,142 @@
+package pack_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/buildpack/pack"
+	"github.com/buildpack/pack/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestBuilderInspector(t *testing.T) {
+	spec.Run(t, "inspect-builder", testBuilderInspector, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testBuilderInspector(t *testing.T, when spec.G, it spec.S) {
+	var (
+		subject        *pack.BuilderInspector
+		mockController *gomock.Controller
+		mockImages     *mocks.MockImages
+		builderImage   v1.Image
+	)
+
+	layer := func(files map[string]string) v1.Layer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for name, content := range files {
+			if content == "" {
+				assertNil(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755}))
+				continue
+			}
+			assertNil(t, tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(content)), Mode: 0644}))
+			_, err := tw.Write([]byte(content))
+			assertNil(t, err)
+		}
+		assertNil(t, tw.Close())
+		l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+		})
+		assertNil(t, err)
+		return l
+	}
+
+	it.Before(func() {
+		mockController = gomock.NewController(t)
+		mockImages = mocks.NewMockImages(mockController)
+		subject = &pack.BuilderInspector{Images: mockImages}
+
+		var err error
+		builderImage, err = random.Image(64, 1)
+		assertNil(t, err)
+		builderImage, err = mutate.AppendLayers(builderImage,
+			layer(map[string]string{
+				"/buildpacks/order.toml": `
+[[groups]]
+  [[groups.buildpacks]]
+    id = "some.bp1"
+    version = "1.2.3"
+  [[groups.buildpacks]]
+    id = "some.bp2"
+    version = "4.5.6"
+
+[[groups]]
+  [[groups.buildpacks]]
+    id = "some.bp1"
+    version = "1.2.3"
+`,
+			}),
+			layer(map[string]string{
+				"/buildpacks/some.bp2/4.5.6/":               "",
+				"/buildpacks/some.bp2/4.5.6/buildpack.toml": "[buildpack]\nid = \"some.bp2\"\n",
+			}),
+			layer(map[string]string{
+				"/buildpacks/some.bp1/1.2.3/":               "",
+				"/buildpacks/some.bp1/1.2.3/buildpack.toml": "[buildpack]\nid = \"some.bp1\"\n",
+			}),
+		)
+		assertNil(t, err)
+		builderImage, err = mutate.Config(builderImage, v1.Config{
+			Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+		})
+		assertNil(t, err)
+	})
+
+	it.After(func() {
+		mockController.Finish()
+	})
+
+	when("#Inspect", func() {
+		it("reads the stack, buildpacks and detection order", func() {
+			mockImages.EXPECT().ReadImage("some/builder", false).Return(builderImage, nil)
+
+			info, err := subject.Inspect("some/builder", false)
+			assertNil(t, err)
+			assertEq(t, info, &pack.BuilderInfo{
+				RepoName: "some/builder",
+				StackID:  "some.stack.id",
+				Buildpacks: []pack.BuilderBuildpack{
+					{ID: "some.bp1", Version: "1.2.3"},
+					{ID: "some.bp2", Version: "4.5.6"},
+				},
+				Groups: [][]pack.BuilderBuildpack{
+					{{ID: "some.bp1", Version: "1.2.3"}, {ID: "some.bp2", Version: "4.5.6"}},
+					{{ID: "some.bp1", Version: "1.2.3"}},
+				},
+			})
+		})
+
+		it("prefers the builder metadata label", func() {
+			builderImage, err := mutate.Config(builderImage, v1.Config{
+				Labels: map[string]string{
+					"io.buildpacks.stack.id": "some.stack.id",
+					pack.BuilderMetadataLabel: `{"buildpacks":[{"id":"other.bp","version":"7.8.9","layer":"sha256:some-layer"}],` +
+						`"groups":[{"buildpacks":[{"id":"other.bp","version":"7.8.9"}]}]}`,
+				},
+			})
+			assertNil(t, err)
+			mockImages.EXPECT().ReadImage("some/builder", false).Return(builderImage, nil)
+
+			info, err := subject.Inspect("some/builder", false)
+			assertNil(t, err)
+			assertEq(t, info.Buildpacks, []pack.BuilderBuildpack{{ID: "other.bp", Version: "7.8.9", Layer: "sha256:some-layer"}})
+			assertEq(t, info.Groups, [][]pack.BuilderBuildpack{{{ID: "other.bp", Version: "7.8.9"}}})
+		})
+
+		it("errors when the builder image is not found", func() {
+			mockImages.EXPECT().ReadImage("some/builder", true).Return(nil, nil)
+
+			_, err := subject.Inspect("some/builder", true)
+			assertError(t, err, `builder image "some/builder" was not found`)
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package pack
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/pack/config"
+	"github.com/buildpack/packs"
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+type ImageInspector struct {
+	Config *config.Config
+	Images Images
+}
+
+type ImageInfo struct {
+	RepoName string              `json:"image"`
+	StackID  string              `json:"stack"`
+	Metadata packs.BuildMetadata `json:"metadata"`
+	// CurrentRunImage is the run image the app would be built on today, and
+	// RunImageOutdated is set when its top layer differs from the one
+	// recorded in the metadata
+	CurrentRunImage  string `json:"current-run-image"`
+	RunImageOutdated bool   `json:"run-image-outdated"`
+}
+
+// Inspect decodes the build metadata of an app image from the daemon or the
+// registry, and compares its run image with the current one of its stack.
+func (i *ImageInspector) Inspect(repoName string, useDaemon bool) (*ImageInfo, error) {
+	image, err := i.Images.ReadImage(repoName, useDaemon)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read image "%s": %s`, repoName, err)
+	}
+	if image == nil {
+		return nil, fmt.Errorf(`image "%s" was not found`, repoName)
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read config of image "%s": %s`, repoName, err)
+	}
+	label := configFile.Config.Labels[lifecycle.MetadataLabel]
+	if label == "" {
+		return nil, fmt.Errorf(`image "%s" is missing label "%s", was it built by pack?`, repoName, lifecycle.MetadataLabel)
+	}
+	info := &ImageInfo{
+		RepoName: repoName,
+		StackID:  configFile.Config.Labels["io.buildpacks.stack.id"],
+	}
+	if err := json.Unmarshal([]byte(label), &info.Metadata); err != nil {
+		return nil, fmt.Errorf(`failed to parse label "%s" of image "%s": %s`, lifecycle.MetadataLabel, repoName, err)
+	}
+
+	info.CurrentRunImage = i.currentRunImage(repoName, info)
+	if info.CurrentRunImage != "" {
+		runImage, err := i.Images.ReadImage(info.CurrentRunImage, useDaemon)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to read run image "%s": %s`, info.CurrentRunImage, err)
+		}
+		if runImage != nil {
+			topLayer, err := topLayerDiffID(runImage)
+			if err != nil {
+				return nil, fmt.Errorf(`failed to read layers of run image "%s": %s`, info.CurrentRunImage, err)
+			}
+			info.RunImageOutdated = topLayer != info.Metadata.RunImage.SHA
+		}
+	}
+	return info, nil
+}
+
+// currentRunImage selects the run image of the image's stack for the registry
+// of the image, falling back to the run image recorded in the metadata when
+// the stack is not in the pack config.
+func (i *ImageInspector) currentRunImage(repoName string, info *ImageInfo) string {
+	if info.StackID != "" {
+		if stack, err := i.Config.Get(info.StackID); err == nil {
+			if reg, err := config.Registry(repoName); err == nil {
+				if runImage, err := config.ImageByRegistry(reg, stack.RunImages); err == nil {
+					return runImage
+				}
+			}
+		}
+	}
+	return info.Metadata.RunImage.Name
+}
+
+func topLayerDiffID(image v1.Image) (string, error) {
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return "", err
+	}
+	diffIDs := configFile.RootFS.DiffIDs
+	if len(diffIDs) == 0 {
+		return "", nil
+	}
+	return diffIDs[len(diffIDs)-1].String(), nil
+}
@@ -0,0 +1,109 @@
+package pack_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/pack"
+	"github.com/buildpack/pack/config"
+	"github.com/buildpack/pack/mocks"
+	"github.com/buildpack/packs"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestImageInspector(t *testing.T) {
+	spec.Run(t, "inspect-image", testImageInspector, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testImageInspector(t *testing.T, when spec.G, it spec.S) {
+	var (
+		subject        *pack.ImageInspector
+		mockController *gomock.Controller
+		mockImages     *mocks.MockImages
+		runImage       v1.Image
+		appImage       v1.Image
+	)
+
+	it.Before(func() {
+		mockController = gomock.NewController(t)
+		mockImages = mocks.NewMockImages(mockController)
+		subject = &pack.ImageInspector{
+			Images: mockImages,
+			Config: &config.Config{
+				Stacks: []config.Stack{
+					{
+						ID:        "some.stack.id",
+						RunImages: []string{"some/run", "registry.com/some/run"},
+					},
+				},
+			},
+		}
+
+		var err error
+		runImage, err = random.Image(64, 2)
+		assertNil(t, err)
+		configFile, err := runImage.ConfigFile()
+		assertNil(t, err)
+		metadata, err := json.Marshal(packs.BuildMetadata{
+			RunImage: packs.RunImageMetadata{Name: "some/run", SHA: configFile.RootFS.DiffIDs[1].String()},
+			App:      packs.AppMetadata{SHA: "some-app-sha"},
+			Buildpacks: []packs.BuildpackMetadata{
+				{Key: "some.buildpack", Layers: map[string]packs.LayerMetadata{"some-layer": {SHA: "some-layer-sha"}}},
+			},
+		})
+		assertNil(t, err)
+		appImage, err = random.Image(64, 1)
+		assertNil(t, err)
+		appImage, err = mutate.Config(appImage, v1.Config{
+			Labels: map[string]string{
+				"io.buildpacks.stack.id": "some.stack.id",
+				lifecycle.MetadataLabel:  string(metadata),
+			},
+		})
+		assertNil(t, err)
+	})
+
+	it.After(func() {
+		mockController.Finish()
+	})
+
+	when("#Inspect", func() {
+		it("decodes the build metadata", func() {
+			mockImages.EXPECT().ReadImage("registry.com/some/app", false).Return(appImage, nil)
+			mockImages.EXPECT().ReadImage("registry.com/some/run", false).Return(runImage, nil)
+
+			info, err := subject.Inspect("registry.com/some/app", false)
+			assertNil(t, err)
+			assertEq(t, info.StackID, "some.stack.id")
+			assertEq(t, info.Metadata.App.SHA, "some-app-sha")
+			assertEq(t, info.Metadata.Buildpacks[0].Layers["some-layer"].SHA, "some-layer-sha")
+			assertEq(t, info.CurrentRunImage, "registry.com/some/run")
+			assertEq(t, info.RunImageOutdated, false)
+		})
+
+		it("reports when the run image has changed", func() {
+			newRunImage, err := random.Image(64, 2)
+			assertNil(t, err)
+			mockImages.EXPECT().ReadImage("some/app", true).Return(appImage, nil)
+			mockImages.EXPECT().ReadImage("some/run", true).Return(newRunImage, nil)
+
+			info, err := subject.Inspect("some/app", true)
+			assertNil(t, err)
+			assertEq(t, info.CurrentRunImage, "some/run")
+			assertEq(t, info.RunImageOutdated, true)
+		})
+
+		it("errors when the image is not found", func() {
+			mockImages.EXPECT().ReadImage("some/app", true).Return(nil, nil)
+
+			_, err := subject.Inspect("some/app", true)
+			assertError(t, err, `image "some/app" was not found`)
+		})
+	})
+}
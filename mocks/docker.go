@@ -8,7 +8,9 @@ import (
 	context "context"
 	types "github.com/docker/docker/api/types"
 	container "github.com/docker/docker/api/types/container"
+	filters "github.com/docker/docker/api/types/filters"
 	network "github.com/docker/docker/api/types/network"
+	volume "github.com/docker/docker/api/types/volume"
 	gomock "github.com/golang/mock/gomock"
 	io "io"
 	reflect "reflect"
@@ -50,6 +52,19 @@ func (mr *MockDockerMockRecorder) ContainerCreate(arg0, arg1, arg2, arg3, arg4 i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerCreate", reflect.TypeOf((*MockDocker)(nil).ContainerCreate), arg0, arg1, arg2, arg3, arg4)
 }
 
+// ContainerList mocks base method
+func (m *MockDocker) ContainerList(arg0 context.Context, arg1 types.ContainerListOptions) ([]types.Container, error) {
+	ret := m.ctrl.Call(m, "ContainerList", arg0, arg1)
+	ret0, _ := ret[0].([]types.Container)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerList indicates an expected call of ContainerList
+func (mr *MockDockerMockRecorder) ContainerList(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerList", reflect.TypeOf((*MockDocker)(nil).ContainerList), arg0, arg1)
+}
+
 // ContainerRemove mocks base method
 func (m *MockDocker) ContainerRemove(arg0 context.Context, arg1 string, arg2 types.ContainerRemoveOptions) error {
 	ret := m.ctrl.Call(m, "ContainerRemove", arg0, arg1, arg2)
@@ -88,17 +103,17 @@ func (mr *MockDockerMockRecorder) CopyToContainer(arg0, arg1, arg2, arg3, arg4 i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyToContainer", reflect.TypeOf((*MockDocker)(nil).CopyToContainer), arg0, arg1, arg2, arg3, arg4)
 }
 
-// ImageBuild mocks base method
-func (m *MockDocker) ImageBuild(arg0 context.Context, arg1 io.Reader, arg2 types.ImageBuildOptions) (types.ImageBuildResponse, error) {
-	ret := m.ctrl.Call(m, "ImageBuild", arg0, arg1, arg2)
-	ret0, _ := ret[0].(types.ImageBuildResponse)
+// DiskUsage mocks base method
+func (m *MockDocker) DiskUsage(arg0 context.Context) (types.DiskUsage, error) {
+	ret := m.ctrl.Call(m, "DiskUsage", arg0)
+	ret0, _ := ret[0].(types.DiskUsage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// ImageBuild indicates an expected call of ImageBuild
-func (mr *MockDockerMockRecorder) ImageBuild(arg0, arg1, arg2 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageBuild", reflect.TypeOf((*MockDocker)(nil).ImageBuild), arg0, arg1, arg2)
+// DiskUsage indicates an expected call of DiskUsage
+func (mr *MockDockerMockRecorder) DiskUsage(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DiskUsage", reflect.TypeOf((*MockDocker)(nil).DiskUsage), arg0)
 }
 
 // ImageInspectWithRaw mocks base method
@@ -115,16 +130,55 @@ func (mr *MockDockerMockRecorder) ImageInspectWithRaw(arg0, arg1 interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageInspectWithRaw", reflect.TypeOf((*MockDocker)(nil).ImageInspectWithRaw), arg0, arg1)
 }
 
+// ImageList mocks base method
+func (m *MockDocker) ImageList(arg0 context.Context, arg1 types.ImageListOptions) ([]types.ImageSummary, error) {
+	ret := m.ctrl.Call(m, "ImageList", arg0, arg1)
+	ret0, _ := ret[0].([]types.ImageSummary)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageList indicates an expected call of ImageList
+func (mr *MockDockerMockRecorder) ImageList(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageList", reflect.TypeOf((*MockDocker)(nil).ImageList), arg0, arg1)
+}
+
+// ImageLoad mocks base method
+func (m *MockDocker) ImageLoad(arg0 context.Context, arg1 io.Reader, arg2 bool) (types.ImageLoadResponse, error) {
+	ret := m.ctrl.Call(m, "ImageLoad", arg0, arg1, arg2)
+	ret0, _ := ret[0].(types.ImageLoadResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageLoad indicates an expected call of ImageLoad
+func (mr *MockDockerMockRecorder) ImageLoad(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageLoad", reflect.TypeOf((*MockDocker)(nil).ImageLoad), arg0, arg1, arg2)
+}
+
+// ImageRemove mocks base method
+func (m *MockDocker) ImageRemove(arg0 context.Context, arg1 string, arg2 types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	ret := m.ctrl.Call(m, "ImageRemove", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]types.ImageDeleteResponseItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageRemove indicates an expected call of ImageRemove
+func (mr *MockDockerMockRecorder) ImageRemove(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageRemove", reflect.TypeOf((*MockDocker)(nil).ImageRemove), arg0, arg1, arg2)
+}
+
 // PullImage mocks base method
-func (m *MockDocker) PullImage(arg0 string) error {
-	ret := m.ctrl.Call(m, "PullImage", arg0)
+func (m *MockDocker) PullImage(arg0 context.Context, arg1 string) error {
+	ret := m.ctrl.Call(m, "PullImage", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // PullImage indicates an expected call of PullImage
-func (mr *MockDockerMockRecorder) PullImage(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PullImage", reflect.TypeOf((*MockDocker)(nil).PullImage), arg0)
+func (mr *MockDockerMockRecorder) PullImage(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PullImage", reflect.TypeOf((*MockDocker)(nil).PullImage), arg0, arg1)
 }
 
 // RunContainer mocks base method
@@ -139,6 +193,45 @@ func (mr *MockDockerMockRecorder) RunContainer(arg0, arg1, arg2, arg3 interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunContainer", reflect.TypeOf((*MockDocker)(nil).RunContainer), arg0, arg1, arg2, arg3)
 }
 
+// VolumeCreate mocks base method
+func (m *MockDocker) VolumeCreate(arg0 context.Context, arg1 volume.VolumeCreateBody) (types.Volume, error) {
+	ret := m.ctrl.Call(m, "VolumeCreate", arg0, arg1)
+	ret0, _ := ret[0].(types.Volume)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VolumeCreate indicates an expected call of VolumeCreate
+func (mr *MockDockerMockRecorder) VolumeCreate(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VolumeCreate", reflect.TypeOf((*MockDocker)(nil).VolumeCreate), arg0, arg1)
+}
+
+// VolumeInspect mocks base method
+func (m *MockDocker) VolumeInspect(arg0 context.Context, arg1 string) (types.Volume, error) {
+	ret := m.ctrl.Call(m, "VolumeInspect", arg0, arg1)
+	ret0, _ := ret[0].(types.Volume)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VolumeInspect indicates an expected call of VolumeInspect
+func (mr *MockDockerMockRecorder) VolumeInspect(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VolumeInspect", reflect.TypeOf((*MockDocker)(nil).VolumeInspect), arg0, arg1)
+}
+
+// VolumeList mocks base method
+func (m *MockDocker) VolumeList(arg0 context.Context, arg1 filters.Args) (volume.VolumeListOKBody, error) {
+	ret := m.ctrl.Call(m, "VolumeList", arg0, arg1)
+	ret0, _ := ret[0].(volume.VolumeListOKBody)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VolumeList indicates an expected call of VolumeList
+func (mr *MockDockerMockRecorder) VolumeList(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VolumeList", reflect.TypeOf((*MockDocker)(nil).VolumeList), arg0, arg1)
+}
+
 // VolumeRemove mocks base method
 func (m *MockDocker) VolumeRemove(arg0 context.Context, arg1 string, arg2 bool) error {
 	ret := m.ctrl.Call(m, "VolumeRemove", arg0, arg1, arg2)
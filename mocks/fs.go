@@ -47,28 +47,28 @@ func (mr *MockFSMockRecorder) CreateSingleFileTar(arg0, arg1 interface{}) *gomoc
 }
 
 // CreateTGZFile mocks base method
-func (m *MockFS) CreateTGZFile(arg0, arg1, arg2 string, arg3, arg4 int) error {
-	ret := m.ctrl.Call(m, "CreateTGZFile", arg0, arg1, arg2, arg3, arg4)
+func (m *MockFS) CreateTGZFile(arg0, arg1, arg2 string, arg3, arg4 int, arg5 []string) error {
+	ret := m.ctrl.Call(m, "CreateTGZFile", arg0, arg1, arg2, arg3, arg4, arg5)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CreateTGZFile indicates an expected call of CreateTGZFile
-func (mr *MockFSMockRecorder) CreateTGZFile(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTGZFile", reflect.TypeOf((*MockFS)(nil).CreateTGZFile), arg0, arg1, arg2, arg3, arg4)
+func (mr *MockFSMockRecorder) CreateTGZFile(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTGZFile", reflect.TypeOf((*MockFS)(nil).CreateTGZFile), arg0, arg1, arg2, arg3, arg4, arg5)
 }
 
 // CreateTarReader mocks base method
-func (m *MockFS) CreateTarReader(arg0, arg1 string, arg2, arg3 int) (io.Reader, chan error) {
-	ret := m.ctrl.Call(m, "CreateTarReader", arg0, arg1, arg2, arg3)
+func (m *MockFS) CreateTarReader(arg0, arg1 string, arg2, arg3 int, arg4 []string) (io.Reader, chan error) {
+	ret := m.ctrl.Call(m, "CreateTarReader", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(io.Reader)
 	ret1, _ := ret[1].(chan error)
 	return ret0, ret1
 }
 
 // CreateTarReader indicates an expected call of CreateTarReader
-func (mr *MockFSMockRecorder) CreateTarReader(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTarReader", reflect.TypeOf((*MockFS)(nil).CreateTarReader), arg0, arg1, arg2, arg3)
+func (mr *MockFSMockRecorder) CreateTarReader(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTarReader", reflect.TypeOf((*MockFS)(nil).CreateTarReader), arg0, arg1, arg2, arg3, arg4)
 }
 
 // Untar mocks base method
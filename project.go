@@ -0,0 +1,84 @@
+package pack
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+const defaultDescriptor = "project.toml"
+
+// ProjectDescriptor holds the per-app defaults for the build flags, read from
+// a project.toml file in the app directory.
+type ProjectDescriptor struct {
+	Image    string `toml:"image"`
+	Builder  string `toml:"builder"`
+	RunImage string `toml:"run-image"`
+	Publish  bool   `toml:"publish"`
+	// NoPull is the same as a PullPolicy of never, kept for the descriptors
+	// written before pull-policy
+	NoPull     bool              `toml:"no-pull"`
+	PullPolicy string            `toml:"pull-policy"`
+	EnvFile    string            `toml:"env-file"`
+	Exclude    []string          `toml:"exclude"`
+	Env        map[string]string `toml:"env"`
+	// CacheKey and CacheKeyFromImage set the build cache key, see BuildFlags
+	CacheKey          string `toml:"cache-key"`
+	CacheKeyFromImage bool   `toml:"cache-key-from-image"`
+}
+
+// ReadProjectDescriptor decodes the descriptor at path. When required is
+// false a missing file is not an error and a nil descriptor is returned.
+func ReadProjectDescriptor(path string, required bool) (*ProjectDescriptor, error) {
+	var descriptor ProjectDescriptor
+	if _, err := toml.DecodeFile(path, &descriptor); err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "read project descriptor %s", path)
+	}
+	if descriptor.EnvFile != "" && !filepath.IsAbs(descriptor.EnvFile) {
+		descriptor.EnvFile = filepath.Join(filepath.Dir(path), descriptor.EnvFile)
+	}
+	return &descriptor, nil
+}
+
+// applyTo returns a copy of the flags where every unset field takes its value
+// from the descriptor. Environment variables are handled separately, since
+// they are merged rather than replaced.
+func (d *ProjectDescriptor) applyTo(f *BuildFlags) *BuildFlags {
+	flags := *f
+	if flags.RepoName == "" {
+		flags.RepoName = d.Image
+	}
+	if flags.Builder == "" {
+		flags.Builder = d.Builder
+	}
+	if flags.RunImage == "" {
+		flags.RunImage = d.RunImage
+	}
+	if flags.CacheKey == "" && !flags.CacheKeyFromImage {
+		flags.CacheKey = d.CacheKey
+		flags.CacheKeyFromImage = d.CacheKeyFromImage
+	}
+	if !flags.PublishSet {
+		flags.Publish = flags.Publish || d.Publish
+	}
+	if flags.PullPolicy == "" {
+		flags.PullPolicy = d.PullPolicy
+		if d.NoPull && flags.PullPolicy == "" {
+			flags.PullPolicy = string(PullNever)
+		}
+	}
+	return &flags
+}
+
+func (d *ProjectDescriptor) envList() []string {
+	var env []string
+	for _, k := range sortedEnvKeys(d.Env) {
+		env = append(env, k+"="+d.Env[k])
+	}
+	return env
+}
@@ -0,0 +1,63 @@
+package pack
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	dockercli "github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// PullPolicy decides when images are pulled before they are used.
+type PullPolicy string
+
+const (
+	// PullAlways pulls images on every use, except those pinned by digest
+	// that are already on the daemon.
+	PullAlways PullPolicy = "always"
+	// PullIfNotPresent pulls images only when they are not on the daemon.
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// PullNever never pulls images.
+	PullNever PullPolicy = "never"
+)
+
+// ParsePullPolicy validates the value of --pull-policy or of
+// default-pull-policy in the pack config.
+func ParsePullPolicy(policy string) (PullPolicy, error) {
+	switch p := PullPolicy(policy); p {
+	case PullAlways, PullIfNotPresent, PullNever:
+		return p, nil
+	}
+	return "", fmt.Errorf(`invalid pull policy "%s": must be one of "always", "if-not-present" or "never"`, policy)
+}
+
+// resolvePullPolicy returns the first policy that is set, from the most to
+// the least specific, defaulting to PullAlways.
+func resolvePullPolicy(policies ...string) (PullPolicy, error) {
+	for _, policy := range policies {
+		if policy != "" {
+			return ParsePullPolicy(policy)
+		}
+	}
+	return PullAlways, nil
+}
+
+// pullImage pulls ref as the policy allows. Images pinned by digest never
+// change, so they are only pulled when they are not on the daemon.
+func pullImage(ctx context.Context, cli Docker, logger *log.Logger, policy PullPolicy, description, ref string) error {
+	if policy == PullNever {
+		return nil
+	}
+	if policy == PullIfNotPresent || strings.Contains(ref, "@") {
+		_, _, err := cli.ImageInspectWithRaw(ctx, ref)
+		if err == nil {
+			return nil
+		} else if !dockercli.IsErrNotFound(err) {
+			return errors.Wrapf(err, "inspect %s '%s'", description, ref)
+		}
+	}
+	logger.Printf("Pulling %s '%s' (use --pull-policy to change when images are pulled)", description, ref)
+	return cli.PullImage(ctx, ref)
+}
@@ -0,0 +1,166 @@
+package pack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/img"
+	"github.com/buildpack/pack/config"
+	"github.com/buildpack/packs"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+type RebaseFactory struct {
+	Log    *log.Logger
+	Docker Docker
+	Images Images
+	// Config provides the default pull policy, it may be nil
+	Config *config.Config
+}
+
+type RebaseFlags struct {
+	RepoName string
+	RunImage string
+	Publish  bool
+	// PullPolicy is one of always, if-not-present or never, defaults to
+	// default-pull-policy in the pack config
+	PullPolicy string
+}
+
+type RebaseConfig struct {
+	RepoName string
+	Repo     img.Store
+	Image    v1.Image
+	Metadata packs.BuildMetadata
+	RunImage string
+	NewBase  v1.Image
+}
+
+func (f *RebaseFactory) RebaseConfigFromFlags(flags RebaseFlags) (RebaseConfig, error) {
+	var err error
+	cfg := RebaseConfig{RepoName: flags.RepoName}
+	cfg.Repo, err = f.Images.RepoStore(flags.RepoName, !flags.Publish)
+	if err != nil {
+		return RebaseConfig{}, fmt.Errorf(`failed to create repository store for image "%s": %s`, flags.RepoName, err)
+	}
+	cfg.Image, err = f.Images.ReadImage(flags.RepoName, !flags.Publish)
+	if err != nil {
+		return RebaseConfig{}, fmt.Errorf(`failed to read image "%s": %s`, flags.RepoName, err)
+	}
+	if cfg.Image == nil {
+		return RebaseConfig{}, fmt.Errorf(`image "%s" was not found`, flags.RepoName)
+	}
+	configFile, err := cfg.Image.ConfigFile()
+	if err != nil {
+		return RebaseConfig{}, fmt.Errorf(`failed to read config of image "%s": %s`, flags.RepoName, err)
+	}
+	label := configFile.Config.Labels[lifecycle.MetadataLabel]
+	if label == "" {
+		return RebaseConfig{}, fmt.Errorf(`image "%s" is missing label "%s", was it built by pack?`, flags.RepoName, lifecycle.MetadataLabel)
+	}
+	if err := json.Unmarshal([]byte(label), &cfg.Metadata); err != nil {
+		return RebaseConfig{}, fmt.Errorf(`failed to parse label "%s" of image "%s": %s`, lifecycle.MetadataLabel, flags.RepoName, err)
+	}
+
+	cfg.RunImage = flags.RunImage
+	if cfg.RunImage == "" {
+		cfg.RunImage = cfg.Metadata.RunImage.Name
+	}
+	if cfg.RunImage == "" {
+		return RebaseConfig{}, fmt.Errorf(`image "%s" does not record its run image, use --run-image`, flags.RepoName)
+	}
+	var defaultPullPolicy string
+	if f.Config != nil {
+		defaultPullPolicy = f.Config.DefaultPullPolicy
+	}
+	pullPolicy, err := resolvePullPolicy(flags.PullPolicy, defaultPullPolicy)
+	if err != nil {
+		return RebaseConfig{}, err
+	}
+	if !flags.Publish {
+		if err := pullImage(context.Background(), f.Docker, f.Log, pullPolicy, "run image", cfg.RunImage); err != nil {
+			return RebaseConfig{}, fmt.Errorf(`failed to pull run image "%s": %s`, cfg.RunImage, err)
+		}
+	}
+	cfg.NewBase, err = f.Images.ReadImage(cfg.RunImage, !flags.Publish)
+	if err != nil {
+		return RebaseConfig{}, fmt.Errorf(`failed to read run image "%s": %s`, cfg.RunImage, err)
+	}
+	if cfg.NewBase == nil {
+		return RebaseConfig{}, fmt.Errorf(`run image "%s" was not found`, cfg.RunImage)
+	}
+	return cfg, nil
+}
+
+// Rebase replaces the run image layers of the image, up to and including the
+// layer recorded in its metadata label, with the layers of the new run image.
+// The app and buildpack layers and the image config are kept, and the label
+// is updated to record the new run image.
+func (f *RebaseFactory) Rebase(cfg RebaseConfig) error {
+	layers, err := cfg.Image.Layers()
+	if err != nil {
+		return fmt.Errorf(`failed to read layers of image "%s": %s`, cfg.RepoName, err)
+	}
+	top := -1
+	for i, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return fmt.Errorf(`failed to read layers of image "%s": %s`, cfg.RepoName, err)
+		}
+		if diffID.String() == cfg.Metadata.RunImage.SHA {
+			top = i
+			break
+		}
+	}
+	if top == -1 {
+		return fmt.Errorf(`image "%s" does not contain the run image layer "%s" recorded in its metadata`, cfg.RepoName, cfg.Metadata.RunImage.SHA)
+	}
+
+	configFile, err := cfg.Image.ConfigFile()
+	if err != nil {
+		return fmt.Errorf(`failed to read config of image "%s": %s`, cfg.RepoName, err)
+	}
+	baseConfigFile, err := cfg.NewBase.ConfigFile()
+	if err != nil {
+		return fmt.Errorf(`failed to read config of run image "%s": %s`, cfg.RunImage, err)
+	}
+	stackID := configFile.Config.Labels["io.buildpacks.stack.id"]
+	baseStackID := baseConfigFile.Config.Labels["io.buildpacks.stack.id"]
+	if stackID != "" && baseStackID != stackID {
+		return fmt.Errorf(`invalid run image "%s": stack "%s" does not match stack "%s" of image "%s"`, cfg.RunImage, baseStackID, stackID, cfg.RepoName)
+	}
+	if len(baseConfigFile.RootFS.DiffIDs) == 0 {
+		return fmt.Errorf(`invalid run image "%s": image has no layers`, cfg.RunImage)
+	}
+
+	newImage, err := mutate.AppendLayers(cfg.NewBase, layers[top+1:]...)
+	if err != nil {
+		return fmt.Errorf(`failed to append layers to run image: %s`, err)
+	}
+
+	metadata := cfg.Metadata
+	metadata.RunImage = packs.RunImageMetadata{
+		Name: cfg.RunImage,
+		SHA:  baseConfigFile.RootFS.DiffIDs[len(baseConfigFile.RootFS.DiffIDs)-1].String(),
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf(`failed to marshal metadata: %s`, err)
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Labels[lifecycle.MetadataLabel] = string(metadataJSON)
+	newImage, err = mutate.Config(newImage, config)
+	if err != nil {
+		return fmt.Errorf(`failed to set config of rebased image: %s`, err)
+	}
+
+	if err := cfg.Repo.Write(newImage); err != nil {
+		return fmt.Errorf(`failed to write image "%s": %s`, cfg.RepoName, err)
+	}
+	f.Log.Printf("Successfully rebased image '%s' on run image '%s'", cfg.RepoName, cfg.RunImage)
+	return nil
+}
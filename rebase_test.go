@@ -0,0 +1,187 @@
+package pack_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/pack"
+	"github.com/buildpack/pack/mocks"
+	"github.com/buildpack/packs"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestRebase(t *testing.T) {
+	spec.Run(t, "rebase", testRebase, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testRebase(t *testing.T, when spec.G, it spec.S) {
+	var (
+		factory        pack.RebaseFactory
+		mockController *gomock.Controller
+		mockDocker     *mocks.MockDocker
+		mockImages     *mocks.MockImages
+		mockStore      *mocks.MockStore
+		oldRunImage    v1.Image
+		newRunImage    v1.Image
+		appImage       v1.Image
+		appLayers      []v1.Layer
+		buf            bytes.Buffer
+	)
+
+	withLabels := func(image v1.Image, labels map[string]string) v1.Image {
+		configFile, err := image.ConfigFile()
+		assertNil(t, err)
+		config := *configFile.Config.DeepCopy()
+		config.Labels = labels
+		image, err = mutate.Config(image, config)
+		assertNil(t, err)
+		return image
+	}
+
+	topDiffID := func(image v1.Image) string {
+		configFile, err := image.ConfigFile()
+		assertNil(t, err)
+		return configFile.RootFS.DiffIDs[len(configFile.RootFS.DiffIDs)-1].String()
+	}
+
+	it.Before(func() {
+		mockController = gomock.NewController(t)
+		mockDocker = mocks.NewMockDocker(mockController)
+		mockImages = mocks.NewMockImages(mockController)
+		mockStore = mocks.NewMockStore(mockController)
+		factory = pack.RebaseFactory{
+			Log:    log.New(&buf, "", log.LstdFlags),
+			Docker: mockDocker,
+			Images: mockImages,
+		}
+
+		var err error
+		oldRunImage, err = random.Image(64, 2)
+		assertNil(t, err)
+		oldRunImage = withLabels(oldRunImage, map[string]string{"io.buildpacks.stack.id": "some.stack.id"})
+		newRunImage, err = random.Image(64, 3)
+		assertNil(t, err)
+		newRunImage = withLabels(newRunImage, map[string]string{"io.buildpacks.stack.id": "some.stack.id"})
+
+		layers, err := random.Image(64, 2)
+		assertNil(t, err)
+		appLayers, err = layers.Layers()
+		assertNil(t, err)
+		appImage, err = mutate.AppendLayers(oldRunImage, appLayers...)
+		assertNil(t, err)
+		metadata, err := json.Marshal(packs.BuildMetadata{
+			RunImage: packs.RunImageMetadata{Name: "some/run", SHA: topDiffID(oldRunImage)},
+			App:      packs.AppMetadata{SHA: "some-app-sha"},
+		})
+		assertNil(t, err)
+		appImage = withLabels(appImage, map[string]string{
+			"io.buildpacks.stack.id": "some.stack.id",
+			lifecycle.MetadataLabel:  string(metadata),
+		})
+	})
+
+	it.After(func() {
+		mockController.Finish()
+	})
+
+	when("#RebaseConfigFromFlags", func() {
+		it("pulls the run image recorded in the image metadata", func() {
+			mockImages.EXPECT().RepoStore("some/app", true).Return(mockStore, nil)
+			mockImages.EXPECT().ReadImage("some/app", true).Return(appImage, nil)
+			mockDocker.EXPECT().PullImage(gomock.Any(), "some/run")
+			mockImages.EXPECT().ReadImage("some/run", true).Return(newRunImage, nil)
+
+			cfg, err := factory.RebaseConfigFromFlags(pack.RebaseFlags{RepoName: "some/app"})
+			assertNil(t, err)
+			assertEq(t, cfg.RunImage, "some/run")
+			assertEq(t, cfg.Metadata.App.SHA, "some-app-sha")
+			assertSameInstance(t, cfg.Repo, mockStore)
+		})
+
+		it("uses the run image from the flags and the registry with --publish", func() {
+			mockImages.EXPECT().RepoStore("some/app", false).Return(mockStore, nil)
+			mockImages.EXPECT().ReadImage("some/app", false).Return(appImage, nil)
+			mockImages.EXPECT().ReadImage("other/run", false).Return(newRunImage, nil)
+
+			cfg, err := factory.RebaseConfigFromFlags(pack.RebaseFlags{
+				RepoName: "some/app",
+				RunImage: "other/run",
+				Publish:  true,
+			})
+			assertNil(t, err)
+			assertEq(t, cfg.RunImage, "other/run")
+		})
+
+		it("errors when the image was not built by pack", func() {
+			mockImages.EXPECT().RepoStore("some/app", true).Return(mockStore, nil)
+			mockImages.EXPECT().ReadImage("some/app", true).Return(oldRunImage, nil)
+
+			_, err := factory.RebaseConfigFromFlags(pack.RebaseFlags{RepoName: "some/app"})
+			assertError(t, err, fmt.Sprintf(`image "some/app" is missing label "%s", was it built by pack?`, lifecycle.MetadataLabel))
+		})
+	})
+
+	when("#Rebase", func() {
+		it("replaces the run image layers and updates the metadata", func() {
+			var written v1.Image
+			mockStore.EXPECT().Write(gomock.Any()).Do(func(image v1.Image) { written = image })
+
+			var metadata packs.BuildMetadata
+			configFile, err := appImage.ConfigFile()
+			assertNil(t, err)
+			assertNil(t, json.Unmarshal([]byte(configFile.Config.Labels[lifecycle.MetadataLabel]), &metadata))
+
+			assertNil(t, factory.Rebase(pack.RebaseConfig{
+				RepoName: "some/app",
+				Repo:     mockStore,
+				Image:    appImage,
+				Metadata: metadata,
+				RunImage: "some/run",
+				NewBase:  newRunImage,
+			}))
+
+			layers, err := written.Layers()
+			assertNil(t, err)
+			assertEq(t, len(layers), 5)
+			for i, layer := range appLayers {
+				expected, err := layer.DiffID()
+				assertNil(t, err)
+				actual, err := layers[3+i].DiffID()
+				assertNil(t, err)
+				assertEq(t, actual.String(), expected.String())
+			}
+
+			configFile, err = written.ConfigFile()
+			assertNil(t, err)
+			var newMetadata packs.BuildMetadata
+			assertNil(t, json.Unmarshal([]byte(configFile.Config.Labels[lifecycle.MetadataLabel]), &newMetadata))
+			assertEq(t, newMetadata.RunImage.SHA, topDiffID(newRunImage))
+			assertEq(t, newMetadata.App.SHA, "some-app-sha")
+		})
+
+		it("errors when the stacks do not match", func() {
+			otherRunImage := withLabels(newRunImage, map[string]string{"io.buildpacks.stack.id": "other.stack.id"})
+			var metadata packs.BuildMetadata
+			metadata.RunImage.SHA = topDiffID(oldRunImage)
+
+			err := factory.Rebase(pack.RebaseConfig{
+				RepoName: "some/app",
+				Repo:     mockStore,
+				Image:    appImage,
+				Metadata: metadata,
+				RunImage: "other/run",
+				NewBase:  otherRunImage,
+			})
+			assertError(t, err, `invalid run image "other/run": stack "other.stack.id" does not match stack "some.stack.id" of image "some/app"`)
+		})
+	})
+}
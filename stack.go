@@ -0,0 +1,53 @@
+package pack
+
+import (
+	"fmt"
+
+	"github.com/buildpack/pack/config"
+	"github.com/pkg/errors"
+)
+
+type StackValidator struct {
+	Cli    Docker
+	Images Images
+}
+
+// Validate checks that every build and run image of the stack exists on the
+// daemon or the registry and carries a matching "io.buildpacks.stack.id" label.
+func (v *StackValidator) Validate(stack config.Stack) error {
+	for _, i := range stack.BuildImages {
+		if err := v.validateImage(stack.ID, i); err != nil {
+			return fmt.Errorf(`invalid build image "%s": %s`, i, err)
+		}
+	}
+	for _, i := range stack.RunImages {
+		if err := v.validateImage(stack.ID, i); err != nil {
+			return fmt.Errorf(`invalid run image "%s": %s`, i, err)
+		}
+	}
+	return nil
+}
+
+func (v *StackValidator) validateImage(stackID, imageName string) error {
+	labels, found, err := imageLabels(v.Cli, v.Images, imageName, true)
+	if err != nil {
+		return err
+	}
+	if !found {
+		labels, found, err = imageLabels(v.Cli, v.Images, imageName, false)
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errors.New("image not found on daemon or registry")
+	}
+	imageStackID := labels["io.buildpacks.stack.id"]
+	if imageStackID == "" {
+		return errors.New(`missing required label "io.buildpacks.stack.id"`)
+	}
+	if imageStackID != stackID {
+		return fmt.Errorf(`stack "%s" from image label does not match stack "%s"`, imageStackID, stackID)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package pack_test
+
+import (
+	"testing"
+
+	"github.com/buildpack/pack"
+	"github.com/buildpack/pack/config"
+	"github.com/buildpack/pack/mocks"
+	dockertypes "github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestStackValidator(t *testing.T) {
+	spec.Run(t, "stack-validator", testStackValidator, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testStackValidator(t *testing.T, when spec.G, it spec.S) {
+	var (
+		subject        *pack.StackValidator
+		mockController *gomock.Controller
+		mockImages     *mocks.MockImages
+		mockDocker     *mocks.MockDocker
+	)
+
+	it.Before(func() {
+		mockController = gomock.NewController(t)
+		mockImages = mocks.NewMockImages(mockController)
+		mockDocker = mocks.NewMockDocker(mockController)
+		subject = &pack.StackValidator{
+			Cli:    mockDocker,
+			Images: mockImages,
+		}
+	})
+
+	it.After(func() {
+		mockController.Finish()
+	})
+
+	daemonImage := func(stackID string) dockertypes.ImageInspect {
+		return dockertypes.ImageInspect{
+			Config: &dockercontainer.Config{
+				Labels: map[string]string{"io.buildpacks.stack.id": stackID},
+			},
+		}
+	}
+
+	when("#Validate", func() {
+		it("accepts images on the daemon with a matching stack label", func() {
+			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/build").Return(daemonImage("some.stack.id"), nil, nil)
+			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/run").Return(daemonImage("some.stack.id"), nil, nil)
+
+			assertNil(t, subject.Validate(config.Stack{
+				ID:          "some.stack.id",
+				BuildImages: []string{"some/build"},
+				RunImages:   []string{"some/run"},
+			}))
+		})
+
+		it("falls back to the registry when the image is not on the daemon", func() {
+			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "registry.com/some/run").Return(dockertypes.ImageInspect{}, nil, notFoundError{})
+			mockRunImage := mocks.NewMockImage(mockController)
+			mockImages.EXPECT().ReadImage("registry.com/some/run", false).Return(mockRunImage, nil)
+			mockRunImage.EXPECT().ConfigFile().Return(&v1.ConfigFile{
+				Config: v1.Config{
+					Labels: map[string]string{"io.buildpacks.stack.id": "some.stack.id"},
+				},
+			}, nil)
+
+			assertNil(t, subject.Validate(config.Stack{
+				ID:        "some.stack.id",
+				RunImages: []string{"registry.com/some/run"},
+			}))
+		})
+
+		it("rejects images with a different stack label", func() {
+			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/build").Return(daemonImage("other.stack.id"), nil, nil)
+
+			err := subject.Validate(config.Stack{
+				ID:          "some.stack.id",
+				BuildImages: []string{"some/build"},
+			})
+			assertError(t, err, `invalid build image "some/build": stack "other.stack.id" from image label does not match stack "some.stack.id"`)
+		})
+
+		it("rejects images without a stack label", func() {
+			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/run").Return(daemonImage(""), nil, nil)
+
+			err := subject.Validate(config.Stack{
+				ID:        "some.stack.id",
+				RunImages: []string{"some/run"},
+			})
+			assertError(t, err, `invalid run image "some/run": missing required label "io.buildpacks.stack.id"`)
+		})
+
+		it("rejects images that cannot be found", func() {
+			mockDocker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some/typo").Return(dockertypes.ImageInspect{}, nil, notFoundError{})
+			mockImages.EXPECT().ReadImage("some/typo", false).Return(nil, nil)
+
+			err := subject.Validate(config.Stack{
+				ID:        "some.stack.id",
+				RunImages: []string{"some/typo"},
+			})
+			assertError(t, err, `invalid run image "some/typo": image not found on daemon or registry`)
+		})
+	})
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string  { return "not found" }
+func (notFoundError) NotFound() bool { return true }
@@ -0,0 +1,10 @@
+package pack
+
+// Version is the version of pack, set at build time with
+// -ldflags "-X github.com/buildpack/pack.Version=<version>"
+var Version = "dev"
+
+const (
+	packVersionLabel = "io.buildpacks.pack.version"
+	packBuildIDLabel = "io.buildpacks.pack.build-id"
+)
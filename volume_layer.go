@@ -0,0 +1,205 @@
+package pack
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+// volumeLayer is a layer made of a directory of a volume mounted in a
+// container. Its contents are streamed with CopyFromContainer each time the
+// layer is read, so the directory is never copied to the host.
+type volumeLayer struct {
+	open   func() (io.ReadCloser, error)
+	digest v1.Hash
+	diffID v1.Hash
+	size   int64
+}
+
+// newVolumeLayer reads the directory dir of the container ctrID once to
+// compute the digests and the size of the layer. The entries of the layer
+// have the same absolute paths as in the container.
+func newVolumeLayer(ctx context.Context, cli Docker, ctrID, dir string) (*volumeLayer, error) {
+	l := &volumeLayer{
+		open: func() (io.ReadCloser, error) {
+			return volumeTar(ctx, cli, ctrID, dir)
+		},
+	}
+
+	rc, err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	diffID := sha256.New()
+	digest := sha256.New()
+	size := &countWriter{}
+	if err := gzipTo(io.MultiWriter(digest, size), io.TeeReader(rc, diffID)); err != nil {
+		return nil, errors.Wrapf(err, "read %s", dir)
+	}
+	l.diffID = v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(diffID.Sum(nil))}
+	l.digest = v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(digest.Sum(nil))}
+	l.size = size.n
+	return l, nil
+}
+
+func (l *volumeLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+func (l *volumeLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+func (l *volumeLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.open()
+}
+
+func (l *volumeLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer rc.Close()
+		pw.CloseWithError(gzipTo(pw, rc))
+	}()
+	return pr, nil
+}
+
+func (l *volumeLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+// gzipTo compresses r into w. The same settings must be used every time the
+// layer is compressed, so that its digest does not change.
+func gzipTo(w io.Writer, r io.Reader) error {
+	zw := gzip.NewWriter(w)
+	if _, err := io.Copy(zw, r); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// volumeTar returns the tar stream of dir in the container ctrID, with the
+// entries renamed from relative to the parent of dir to absolute paths.
+func volumeTar(ctx context.Context, cli Docker, ctrID, dir string) (io.ReadCloser, error) {
+	rc, _, err := cli.CopyFromContainer(ctx, ctrID, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "copy %s from container", dir)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer rc.Close()
+		pw.CloseWithError(rebaseTar(pw, rc, path.Dir(dir)))
+	}()
+	return pr, nil
+}
+
+func rebaseTar(w io.Writer, r io.Reader, parent string) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(parent, hdr.Name)
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = path.Join(parent, hdr.Linkname)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// progressLayer reports the progress of reading the compressed contents of a
+// layer, which happens when the layer is uploaded.
+type progressLayer struct {
+	v1.Layer
+	name     string
+	progress *layerProgress
+}
+
+func (l *progressLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	size, err := l.Layer.Size()
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &progressReader{ReadCloser: rc, name: l.name, size: size, progress: l.progress}, nil
+}
+
+// layerProgress prints the progress of the layers uploaded concurrently, one
+// line for every tenth of a layer.
+type layerProgress struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (p *layerProgress) report(name string, percent int, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if percent == 100 {
+		fmt.Fprintf(p.out, "uploaded layer '%s' (%d bytes)\n", name, size)
+		return
+	}
+	fmt.Fprintf(p.out, "uploading layer '%s': %d%% of %d bytes\n", name, percent, size)
+}
+
+type progressReader struct {
+	io.ReadCloser
+	name     string
+	size     int64
+	read     int64
+	reported int
+	progress *layerProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	percent := 100
+	if r.size > 0 && r.read < r.size {
+		percent = int(r.read * 100 / r.size)
+	}
+	if step := percent / 10 * 10; step > r.reported {
+		r.reported = step
+		r.progress.report(r.name, step, r.size)
+	}
+	return n, err
+}
@@ -0,0 +1,175 @@
+package pack_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/buildpack/pack"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestVolumeLayer(t *testing.T) {
+	spec.Run(t, "volume-layer", testVolumeLayer, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testVolumeLayer(t *testing.T, when spec.G, it spec.S) {
+	when("#RebaseTar", func() {
+		for _, tc := range []struct {
+			desc     string
+			parent   string
+			entries  []tarEntry
+			expected []tarEntry
+		}{
+			{
+				desc:   "moves files and directories under the parent",
+				parent: "/workspace",
+				entries: []tarEntry{
+					{Name: "app", Typeflag: tar.TypeDir},
+					{Name: "app/file.txt", Typeflag: tar.TypeReg, Content: "content"},
+				},
+				expected: []tarEntry{
+					{Name: "/workspace/app/", Typeflag: tar.TypeDir},
+					{Name: "/workspace/app/file.txt", Typeflag: tar.TypeReg, Content: "content"},
+				},
+			},
+			{
+				desc:   "keeps a single trailing slash on directories",
+				parent: "/workspace/some-bp",
+				entries: []tarEntry{
+					{Name: "layer/", Typeflag: tar.TypeDir},
+					{Name: "layer/sub/", Typeflag: tar.TypeDir},
+				},
+				expected: []tarEntry{
+					{Name: "/workspace/some-bp/layer/", Typeflag: tar.TypeDir},
+					{Name: "/workspace/some-bp/layer/sub/", Typeflag: tar.TypeDir},
+				},
+			},
+			{
+				desc:   "moves the target of hard links, which is a path in the archive",
+				parent: "/workspace",
+				entries: []tarEntry{
+					{Name: "app/file.txt", Typeflag: tar.TypeReg, Content: "content"},
+					{Name: "app/hardlink", Typeflag: tar.TypeLink, Linkname: "app/file.txt"},
+				},
+				expected: []tarEntry{
+					{Name: "/workspace/app/file.txt", Typeflag: tar.TypeReg, Content: "content"},
+					{Name: "/workspace/app/hardlink", Typeflag: tar.TypeLink, Linkname: "/workspace/app/file.txt"},
+				},
+			},
+			{
+				desc:   "keeps the target of symlinks, which is a path in the file system",
+				parent: "/workspace",
+				entries: []tarEntry{
+					{Name: "app/relative", Typeflag: tar.TypeSymlink, Linkname: "file.txt"},
+					{Name: "app/absolute", Typeflag: tar.TypeSymlink, Linkname: "/etc/hosts"},
+				},
+				expected: []tarEntry{
+					{Name: "/workspace/app/relative", Typeflag: tar.TypeSymlink, Linkname: "file.txt"},
+					{Name: "/workspace/app/absolute", Typeflag: tar.TypeSymlink, Linkname: "/etc/hosts"},
+				},
+			},
+		} {
+			tc := tc
+			it(tc.desc, func() {
+				var out bytes.Buffer
+				assertNil(t, pack.RebaseTar(&out, bytes.NewReader(makeTar(t, tc.entries)), tc.parent))
+				assertEq(t, readTar(t, &out), tc.expected)
+			})
+		}
+
+		it("fails on a truncated archive", func() {
+			archive := makeTar(t, []tarEntry{{Name: "app/file.txt", Typeflag: tar.TypeReg, Content: strings.Repeat("x", 1024)}})
+			err := pack.RebaseTar(ioutil.Discard, bytes.NewReader(archive[:700]), "/workspace")
+			assertNotNil(t, err)
+		})
+	})
+
+	when("#progressReader", func() {
+		for _, tc := range []struct {
+			desc     string
+			size     int64
+			chunk    int
+			expected []string
+		}{
+			{
+				desc:  "reports every tenth of the layer and its completion",
+				size:  100,
+				chunk: 25,
+				expected: []string{
+					"uploading layer 'some-layer': 20% of 100 bytes",
+					"uploading layer 'some-layer': 50% of 100 bytes",
+					"uploading layer 'some-layer': 70% of 100 bytes",
+					"uploaded layer 'some-layer' (100 bytes)",
+				},
+			},
+			{
+				desc:     "reports only the completion of a layer read at once",
+				size:     100,
+				chunk:    100,
+				expected: []string{"uploaded layer 'some-layer' (100 bytes)"},
+			},
+			{
+				desc:     "reports the completion of an empty layer",
+				size:     0,
+				chunk:    10,
+				expected: []string{"uploaded layer 'some-layer' (0 bytes)"},
+			},
+		} {
+			tc := tc
+			it(tc.desc, func() {
+				var out bytes.Buffer
+				rc := pack.NewProgressReader(ioutil.NopCloser(strings.NewReader(strings.Repeat("x", int(tc.size)))), "some-layer", tc.size, &out)
+				buf := make([]byte, tc.chunk)
+				for {
+					_, err := rc.Read(buf)
+					if err == io.EOF {
+						break
+					}
+					assertNil(t, err)
+				}
+				assertEq(t, strings.Split(strings.TrimSpace(out.String()), "\n"), tc.expected)
+			})
+		}
+	})
+}
+
+type tarEntry struct {
+	Name     string
+	Typeflag byte
+	Linkname string
+	Content  string
+}
+
+func makeTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		assertNil(t, tw.WriteHeader(&tar.Header{Name: e.Name, Typeflag: e.Typeflag, Linkname: e.Linkname, Mode: 0644, Size: int64(len(e.Content))}))
+		_, err := tw.Write([]byte(e.Content))
+		assertNil(t, err)
+	}
+	assertNil(t, tw.Close())
+	return buf.Bytes()
+}
+
+func readTar(t *testing.T, r io.Reader) []tarEntry {
+	t.Helper()
+	var entries []tarEntry
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		assertNil(t, err)
+		content, err := ioutil.ReadAll(tr)
+		assertNil(t, err)
+		entries = append(entries, tarEntry{Name: hdr.Name, Typeflag: hdr.Typeflag, Linkname: hdr.Linkname, Content: string(content)})
+	}
+}